@@ -1,3 +1,3 @@
 module github.com/mutalisk999/go-lib
 
-go 1.14
+go 1.18
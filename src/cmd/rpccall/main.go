@@ -0,0 +1,307 @@
+// rpccall calls methods of a JSON-RPC 2.0 server over TCP or HTTP and prints the results.
+//
+//	rpccall -addr 127.0.0.1:8080 getBalance '"alice"' 10
+//	echo '{"name":"alice"}' | rpccall -url http://127.0.0.1:8080 -params - getUser
+//	rpccall -url http://127.0.0.1:8080 -batch calls.json
+//
+// Every argument after the method is a positional param, taken as JSON when it parses and
+// as a string otherwise. A batch file holds a JSON array of {"method", "params", "notify"} objects.
+// The exit status is 1 when a call returned an error object and 2 when no call could be made.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_cli"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	exitOk       = 0
+	exitRpcError = 1
+	exitFailure  = 2
+)
+
+type batchEntry struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Notify bool            `json:"notify,omitempty"`
+}
+
+type batchResult struct {
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  interface{}     `json:"error,omitempty"`
+}
+
+type options struct {
+	addr    string
+	url     string
+	timeOut float64
+	params  string
+	batch   string
+	notify  bool
+	pretty  bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	opts := options{}
+	flags := flag.NewFlagSet("rpccall", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.addr, "addr", "", "host:port of a server speaking newline delimited JSON over TCP")
+	flags.StringVar(&opts.url, "url", "", "url of a server taking HTTP POSTs")
+	flags.Float64Var(&opts.timeOut, "timeout", 10, "timeout of connecting and of every call in seconds")
+	flags.StringVar(&opts.params, "params", "", "params as one JSON array or object, - reads them from stdin")
+	flags.StringVar(&opts.batch, "batch", "", "file of a JSON array of calls to send as one batch, - reads stdin")
+	flags.BoolVar(&opts.notify, "notify", false, "send the call as a notification, which gets no response")
+	flags.BoolVar(&opts.pretty, "pretty", true, "indent the printed JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: rpccall (-addr host:port | -url url) [flags] method [param ...]")
+		fmt.Fprintln(stderr, "       rpccall (-addr host:port | -url url) [flags] -batch file")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return exitFailure
+	}
+	if (opts.addr == "") == (opts.url == "") {
+		fmt.Fprintln(stderr, "rpccall: exactly one of -addr and -url is needed")
+		return exitFailure
+	}
+
+	rpcClient, err := connect(opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall: connect:", err)
+		return exitFailure
+	}
+	defer rpcClient.RpcDisConnect()
+
+	if opts.batch != "" {
+		if flags.NArg() > 0 || opts.params != "" {
+			fmt.Fprintln(stderr, "rpccall: -batch takes neither a method nor params")
+			return exitFailure
+		}
+		return runBatch(rpcClient, opts, stdin, stdout, stderr)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitFailure
+	}
+	params, err := parseParams(opts.params, flags.Args()[1:], stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall: params:", err)
+		return exitFailure
+	}
+	return runCall(rpcClient, opts, flags.Arg(0), params, stdout, stderr)
+}
+
+func connect(opts options) (*jsonrpc_cli.RpcClient, error) {
+	rpcClient := new(jsonrpc_cli.RpcClient)
+	if opts.url != "" {
+		err := rpcClient.RpcConnectHttp(jsonrpc_cli.HttpConfig{URL: opts.url, TimeOut: opts.timeOut})
+		if err != nil {
+			return nil, err
+		}
+		return rpcClient, nil
+	}
+
+	host, port, err := net.SplitHostPort(opts.addr)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid port " + port)
+	}
+	err = rpcClient.RpcConnectV2(host, uint16(portNum), opts.timeOut)
+	if err != nil {
+		return nil, err
+	}
+	rpcClient.SetCallTimeOut(opts.timeOut)
+	return rpcClient, nil
+}
+
+// parseParams returns the params of -params or of the arguments, nil for none
+func parseParams(params string, args []string, stdin io.Reader) (json.RawMessage, error) {
+	if params != "" && len(args) > 0 {
+		return nil, errors.New("use either -params or param arguments")
+	}
+	if params == "-" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		params = string(bytes.TrimSpace(data))
+		if params == "" {
+			return nil, nil
+		}
+	}
+	if params != "" {
+		if !json.Valid([]byte(params)) {
+			return nil, errors.New("invalid JSON")
+		}
+		return json.RawMessage(params), nil
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	positional := make([]json.RawMessage, 0, len(args))
+	for _, arg := range args {
+		if json.Valid([]byte(arg)) {
+			positional = append(positional, json.RawMessage(arg))
+			continue
+		}
+		encoded, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		positional = append(positional, encoded)
+	}
+	return json.Marshal(positional)
+}
+
+func runCall(rpcClient *jsonrpc_cli.RpcClient, opts options, method string, params json.RawMessage,
+	stdout io.Writer, stderr io.Writer) int {
+	// a nil json.RawMessage would be sent as null params
+	var arg interface{}
+	if params != nil {
+		arg = params
+	}
+	if opts.notify {
+		err := rpcClient.RpcNotify(method, arg)
+		if err != nil {
+			fmt.Fprintln(stderr, "rpccall:", err)
+			return exitFailure
+		}
+		return exitOk
+	}
+
+	ctx, cancel := callContext(opts)
+	defer cancel()
+	var result json.RawMessage
+	err := rpcClient.RpcRequestContext(ctx, method, arg, &result)
+	if err != nil {
+		rpcErr, ok := err.(*jsonrpc_cli.RpcError)
+		if !ok {
+			fmt.Fprintln(stderr, "rpccall:", err)
+			return exitFailure
+		}
+		fmt.Fprintln(stderr, "rpccall:", rpcErr)
+		_ = printJSON(stdout, map[string]interface{}{"error": rpcErr}, opts.pretty)
+		return exitRpcError
+	}
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+	err = printJSON(stdout, result, opts.pretty)
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall:", err)
+		return exitFailure
+	}
+	return exitOk
+}
+
+func runBatch(rpcClient *jsonrpc_cli.RpcClient, opts options, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	var data []byte
+	var err error
+	if opts.batch == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(opts.batch)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall: batch:", err)
+		return exitFailure
+	}
+	entries := make([]batchEntry, 0)
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall: batch:", err)
+		return exitFailure
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(stderr, "rpccall: batch: no calls")
+		return exitFailure
+	}
+
+	calls := make([]*jsonrpc_cli.RpcBatchCall, len(entries))
+	for i, entry := range entries {
+		calls[i] = &jsonrpc_cli.RpcBatchCall{Method: entry.Method, Notify: entry.Notify, Reply: new(json.RawMessage)}
+		if len(entry.Params) > 0 {
+			calls[i].Arg = entry.Params
+		}
+	}
+	ctx, cancel := callContext(opts)
+	defer cancel()
+	err = rpcClient.RpcBatchContext(ctx, calls)
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall: batch:", err)
+		return exitFailure
+	}
+
+	status := exitOk
+	results := make([]batchResult, 0, len(calls))
+	for _, call := range calls {
+		if call.Notify {
+			continue
+		}
+		res := batchResult{Method: call.Method}
+		if call.Error != nil {
+			status = exitRpcError
+			if rpcErr, ok := call.Error.(*jsonrpc_cli.RpcError); ok {
+				res.Error = rpcErr
+			} else {
+				res.Error = map[string]string{"message": call.Error.Error()}
+			}
+		} else {
+			res.Result = *call.Reply.(*json.RawMessage)
+			if len(res.Result) == 0 {
+				res.Result = json.RawMessage("null")
+			}
+		}
+		results = append(results, res)
+	}
+	err = printJSON(stdout, results, opts.pretty)
+	if err != nil {
+		fmt.Fprintln(stderr, "rpccall:", err)
+		return exitFailure
+	}
+	return status
+}
+
+func callContext(opts options) (context.Context, context.CancelFunc) {
+	if opts.timeOut <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), time.Duration(opts.timeOut*1000*1000*1000))
+}
+
+func printJSON(w io.Writer, value interface{}, pretty bool) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if pretty {
+		indented := new(bytes.Buffer)
+		err = json.Indent(indented, encoded, "", "  ")
+		if err != nil {
+			return err
+		}
+		encoded = indented.Bytes()
+	}
+	_, err = w.Write(append(encoded, '\n'))
+	return err
+}
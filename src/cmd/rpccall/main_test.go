@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_mock"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func startMock(t *testing.T) (*jsonrpc_mock.MockServer, string, string) {
+	mock := new(jsonrpc_mock.MockServer)
+	mock.Initialise("mock")
+	t.Cleanup(mock.Close)
+	port, err := mock.ServeTcp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := mock.ServeHttp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mock, "127.0.0.1:" + strconv.Itoa(int(port)), url
+}
+
+func runArgs(stdin string, args ...string) (int, string, string) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	status := run(args, strings.NewReader(stdin), stdout, stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestRunCall(t *testing.T) {
+	mock, addr, url := startMock(t)
+	mock.Expect("add").WithParams([]interface{}{1, "two"}).Return(3)
+	mock.Expect("user").WithParams(map[string]string{"name": "alice"}).Return(map[string]int{"age": 30})
+	mock.Expect("fail").ReturnError(-32001, "no funds", nil)
+
+	status, stdout, stderr := runArgs("", "-addr", addr, "add", "1", "two")
+	if status != exitOk || stdout != "3\n" {
+		t.Fatal("unexpected output", status, stdout, stderr)
+	}
+	status, stdout, stderr = runArgs(`{"name":"alice"}`, "-url", url, "-params", "-", "user")
+	if status != exitOk || stdout != "{\n  \"age\": 30\n}\n" {
+		t.Fatal("unexpected output", status, stdout, stderr)
+	}
+	status, stdout, _ = runArgs("", "-url", url, "-pretty=false", "-params", `{"name":"alice"}`, "user")
+	if status != exitOk || stdout != "{\"age\":30}\n" {
+		t.Fatal("unexpected output", status, stdout)
+	}
+	status, stdout, stderr = runArgs("", "-addr", addr, "fail")
+	if status != exitRpcError || !strings.Contains(stdout, "-32001") || !strings.Contains(stderr, "no funds") {
+		t.Fatal("unexpected output", status, stdout, stderr)
+	}
+
+	status, _, _ = runArgs("", "add")
+	if status != exitFailure {
+		t.Fatal("expected a missing endpoint to fail", status)
+	}
+	status, _, _ = runArgs("", "-addr", addr, "-params", "[1", "add")
+	if status != exitFailure {
+		t.Fatal("expected invalid params to fail", status)
+	}
+	status, _, _ = runArgs("", "-addr", "127.0.0.1:1", "-timeout", "1", "add")
+	if status != exitFailure {
+		t.Fatal("expected the connect to fail", status)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	mock, addr, url := startMock(t)
+	mock.Expect("add").WithParams([]int{1, 2}).Return(3)
+	mock.Expect("log")
+	mock.Expect("fail").ReturnError(-32001, "no funds", nil)
+
+	path := filepath.Join(t.TempDir(), "calls.json")
+	err := os.WriteFile(path, []byte(`[{"method":"add","params":[1,2]},{"method":"log","params":["x"],"notify":true}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range [][]string{{"-addr", addr}, {"-url", url}} {
+		status, stdout, stderr := runArgs("", append(endpoint, "-batch", path)...)
+		if status != exitOk {
+			t.Fatal("unexpected status", status, stderr)
+		}
+		results := make([]batchResult, 0)
+		err = json.Unmarshal([]byte(stdout), &results)
+		if err != nil || len(results) != 1 || string(results[0].Result) != "3" {
+			t.Fatal("unexpected output", stdout, err)
+		}
+	}
+
+	status, stdout, _ := runArgs(`[{"method":"add","params":[1,2]},{"method":"fail"}]`, "-addr", addr, "-batch", "-")
+	if status != exitRpcError || !strings.Contains(stdout, "no funds") || !strings.Contains(stdout, `"result": 3`) {
+		t.Fatal("unexpected output", status, stdout)
+	}
+}
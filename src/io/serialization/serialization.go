@@ -1,6 +1,7 @@
 package serialization
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -105,6 +106,16 @@ func PackString(writer io.Writer, argString string) error {
 	return err
 }
 
+func PackBytes(writer io.Writer, argBytes []byte) error {
+	bytesLen := uint32(len(argBytes))
+	err := PackUint32(writer, bytesLen)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(argBytes)
+	return err
+}
+
 func Pack(writer io.Writer, argPack interface{}) error {
 	typeKind := reflect.TypeOf(argPack).Kind()
 	typeValue := reflect.ValueOf(argPack)
@@ -194,7 +205,7 @@ func Pack(writer io.Writer, argPack interface{}) error {
 
 func UnPackBool(reader io.Reader) (bool, error) {
 	var bytes [1]byte
-	_, err := reader.Read(bytes[0:1])
+	_, err := io.ReadFull(reader, bytes[0:1])
 	if err != nil {
 		return false, err
 	}
@@ -209,7 +220,7 @@ func UnPackBool(reader io.Reader) (bool, error) {
 
 func UnPackInt8(reader io.Reader) (int8, error) {
 	var bytes [1]byte
-	_, err := reader.Read(bytes[0:1])
+	_, err := io.ReadFull(reader, bytes[0:1])
 	if err != nil {
 		return 0, err
 	}
@@ -218,7 +229,7 @@ func UnPackInt8(reader io.Reader) (int8, error) {
 
 func UnPackUint8(reader io.Reader) (uint8, error) {
 	var bytes [1]byte
-	_, err := reader.Read(bytes[0:1])
+	_, err := io.ReadFull(reader, bytes[0:1])
 	if err != nil {
 		return 0, err
 	}
@@ -227,7 +238,7 @@ func UnPackUint8(reader io.Reader) (uint8, error) {
 
 func UnPackInt16(reader io.Reader) (int16, error) {
 	var bytes [2]byte
-	_, err := reader.Read(bytes[0:2])
+	_, err := io.ReadFull(reader, bytes[0:2])
 	if err != nil {
 		return 0, err
 	}
@@ -236,7 +247,7 @@ func UnPackInt16(reader io.Reader) (int16, error) {
 
 func UnPackUint16(reader io.Reader) (uint16, error) {
 	var bytes [2]byte
-	_, err := reader.Read(bytes[0:2])
+	_, err := io.ReadFull(reader, bytes[0:2])
 	if err != nil {
 		return 0, err
 	}
@@ -245,7 +256,7 @@ func UnPackUint16(reader io.Reader) (uint16, error) {
 
 func UnPackInt32(reader io.Reader) (int32, error) {
 	var bytes [4]byte
-	_, err := reader.Read(bytes[0:4])
+	_, err := io.ReadFull(reader, bytes[0:4])
 	if err != nil {
 		return 0, err
 	}
@@ -254,7 +265,7 @@ func UnPackInt32(reader io.Reader) (int32, error) {
 
 func UnPackUint32(reader io.Reader) (uint32, error) {
 	var bytes [4]byte
-	_, err := reader.Read(bytes[0:4])
+	_, err := io.ReadFull(reader, bytes[0:4])
 	if err != nil {
 		return 0, err
 	}
@@ -263,7 +274,7 @@ func UnPackUint32(reader io.Reader) (uint32, error) {
 
 func UnPackInt64(reader io.Reader) (int64, error) {
 	var bytes [8]byte
-	_, err := reader.Read(bytes[0:8])
+	_, err := io.ReadFull(reader, bytes[0:8])
 	if err != nil {
 		return 0, err
 	}
@@ -272,7 +283,7 @@ func UnPackInt64(reader io.Reader) (int64, error) {
 
 func UnPackUint64(reader io.Reader) (uint64, error) {
 	var bytes [8]byte
-	_, err := reader.Read(bytes[0:8])
+	_, err := io.ReadFull(reader, bytes[0:8])
 	if err != nil {
 		return 0, err
 	}
@@ -281,7 +292,7 @@ func UnPackUint64(reader io.Reader) (uint64, error) {
 
 func UnPackInt(reader io.Reader) (int, error) {
 	var bytes [8]byte
-	_, err := reader.Read(bytes[0:8])
+	_, err := io.ReadFull(reader, bytes[0:8])
 	if err != nil {
 		return 0, err
 	}
@@ -290,7 +301,7 @@ func UnPackInt(reader io.Reader) (int, error) {
 
 func UnPackUint(reader io.Reader) (uint, error) {
 	var bytes [8]byte
-	_, err := reader.Read(bytes[0:8])
+	_, err := io.ReadFull(reader, bytes[0:8])
 	if err != nil {
 		return 0, err
 	}
@@ -299,7 +310,7 @@ func UnPackUint(reader io.Reader) (uint, error) {
 
 func UnPackFloat32(reader io.Reader) (float32, error) {
 	var bytes [4]byte
-	_, err := reader.Read(bytes[0:4])
+	_, err := io.ReadFull(reader, bytes[0:4])
 	if err != nil {
 		return 0.0, err
 	}
@@ -309,7 +320,7 @@ func UnPackFloat32(reader io.Reader) (float32, error) {
 
 func UnPackFloat64(reader io.Reader) (float64, error) {
 	var bytes [8]byte
-	_, err := reader.Read(bytes[0:8])
+	_, err := io.ReadFull(reader, bytes[0:8])
 	if err != nil {
 		return 0.0, err
 	}
@@ -317,17 +328,67 @@ func UnPackFloat64(reader io.Reader) (float64, error) {
 	return math.Float64frombits(bits), nil
 }
 
+// maxPreAlloc bounds the bytes allocated for a length prefix before they are read
+const maxPreAlloc = 64 * 1024
+
+// lenReader is implemented by readers knowing the bytes left, e.g. *bytes.Reader
+type lenReader interface {
+	Len() int
+}
+
+// checkLength rejects a length prefix larger than the bytes left in reader, when reader knows them
+func checkLength(reader io.Reader, length uint32) error {
+	lr, ok := reader.(lenReader)
+	if ok && uint64(length) > uint64(lr.Len()) {
+		return errors.New(fmt.Sprintf("length %d exceeds the %d bytes left", length, lr.Len()))
+	}
+	return nil
+}
+
+// readLength reads length bytes, growing the buffer with the bytes read so a forged
+// length cannot allocate more than the reader delivers
+func readLength(reader io.Reader, length uint32) ([]byte, error) {
+	err := checkLength(reader, length)
+	if err != nil {
+		return nil, err
+	}
+	if length <= maxPreAlloc {
+		buf := make([]byte, length)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, maxPreAlloc))
+	_, err = io.CopyN(buf, reader, int64(length))
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func UnPackString(reader io.Reader) (string, error) {
 	strLen, err := UnPackUint32(reader)
 	if err != nil {
 		return "", err
 	}
-	bytes := make([]byte, strLen)
-	_, err = reader.Read(bytes[0:strLen])
+	buf, err := readLength(reader, strLen)
 	if err != nil {
 		return "", err
 	}
-	return string(bytes), nil
+	return string(buf), nil
+}
+
+func UnPackBytes(reader io.Reader) ([]byte, error) {
+	bytesLen, err := UnPackUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	return readLength(reader, bytesLen)
 }
 
 func UnPack(reader io.Reader, ty reflect.Type) (interface{}, error) {
@@ -377,7 +438,19 @@ func UnPack(reader io.Reader, ty reflect.Type) (interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		slice := reflect.MakeSlice(ty, 0, int(sliceLen))
+		// packed elements take a byte at least, except structs packing themselves and empty types
+		elemSize := int(ty.Elem().Size())
+		if ty.Elem().Kind() != reflect.Struct && elemSize > 0 {
+			err = checkLength(reader, sliceLen)
+			if err != nil {
+				return nil, err
+			}
+		}
+		capacity := int(sliceLen)
+		if elemSize > 0 && capacity > maxPreAlloc/elemSize {
+			capacity = maxPreAlloc / elemSize
+		}
+		slice := reflect.MakeSlice(ty, 0, capacity)
 		for i := 0; i < int(sliceLen); i++ {
 			val, err := UnPack(reader, ty.Elem())
 			if err != nil {
@@ -1,6 +1,7 @@
 package serialization
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -141,3 +142,32 @@ func TestUnSerialize(t *testing.T) {
 	c = it.(TestStruct)
 	fmt.Println(c)
 }
+
+func TestUnPackForgedLength(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_ = PackUint32(buf, 0xffffffff)
+	buf.WriteString("short")
+	_, err := UnPackString(bytes.NewReader(buf.Bytes()))
+	if err == nil {
+		t.Fatal("expected the string length to be rejected")
+	}
+	_, err = UnPackBytes(bytes.NewReader(buf.Bytes()))
+	if err == nil {
+		t.Fatal("expected the bytes length to be rejected")
+	}
+	_, err = UnPack(bytes.NewReader(buf.Bytes()), reflect.TypeOf([]int64{}))
+	if err == nil {
+		t.Fatal("expected the slice length to be rejected")
+	}
+
+	// readers without Len fail at the end of the data
+	_, err = UnPackString(io.MultiReader(bytes.NewReader(buf.Bytes())))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatal("expected unexpected EOF", err)
+	}
+
+	s, err := UnPackString(bytes.NewReader(append([]byte{5, 0, 0, 0}, "exact"...)))
+	if err != nil || s != "exact" {
+		t.Fatal("unexpected string", s, err)
+	}
+}
@@ -1,97 +1,470 @@
 package buffer_tcp
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
+	"github.com/mutalisk999/go-lib/src/io/serialization"
 	"io"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	DefaultReadChunkSize = 4096
+	DefaultSendFlushSize = 40960
+	DefaultMaxBufferSize = 4 * 1024 * 1024
+	// seconds a listener waits for the handshake of a connection when its timeout is not set
+	DefaultHandshakeTimeOut = 10
+)
+
+type BufferTcpConfig struct {
+	// bytes requested from the socket per read
+	ReadChunkSize uint32
+	// send buffer size which triggers an implicit flush in TCPWrite
+	SendFlushSize uint32
+	// upper bound of bytes held in the read buffer
+	MaxBufferSize uint32
+}
+
+func (c BufferTcpConfig) withDefaults() BufferTcpConfig {
+	if c.ReadChunkSize == 0 {
+		c.ReadChunkSize = DefaultReadChunkSize
+	}
+	if c.SendFlushSize == 0 {
+		c.SendFlushSize = DefaultSendFlushSize
+	}
+	if c.MaxBufferSize == 0 {
+		c.MaxBufferSize = DefaultMaxBufferSize
+	}
+	if c.ReadChunkSize > c.MaxBufferSize {
+		c.ReadChunkSize = c.MaxBufferSize
+	}
+	return c
+}
+
 type BufferTcpConn struct {
 	conn       net.Conn
+	config     BufferTcpConfig
 	sendBuffer []byte
-	readBuffer []byte
+	readBuffer *ringBuffer
+	readEOF    bool
+	closed     uint32
+	lastRecv   int64
+
+	closeMutex   *sync.Mutex
+	closeTimeOut float64
+	firstErr     error
+	closeReason  error
+	onClose      func(reason error)
+
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+	proxyHeader  *ProxyHeader
+
+	compression  *compressor
+	compressedIn *ringBuffer
+	pendingOut   []byte
+
+	counters         *connCounters
+	listenerCounters *listenerCounters
 }
 
+var _ net.Conn = (*BufferTcpConn)(nil)
+var _ io.ByteReader = (*BufferTcpConn)(nil)
+
 type TcpListener struct {
 	tcpAddr  *net.TCPAddr
-	listener *net.TCPListener
+	listener net.Listener
+	config   BufferTcpConfig
+	maxConns int64
+	counters *listenerCounters
+
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+
+	keepAlivePeriod time.Duration
+
+	proxyProtocol      bool
+	proxyHeaderTimeOut float64
+
+	// connections reading their handshakes, counted against maxConns
+	handshaking   int64
+	handshakeOnce *sync.Once
+	handshaken    chan *BufferTcpConn
+	acceptDone    chan struct{}
+	acceptErr     error
+
+	compression        *CompressionConfig
+	compressionTimeOut float64
+}
+
+// SetConfig replaces the config, fields left 0 take their defaults
+func (c *BufferTcpConn) SetConfig(config BufferTcpConfig) {
+	c.config = config.withDefaults()
+}
+
+// SetRateLimiters throttles the socket reads and writes of the connection, nil disables a direction
+func (c *BufferTcpConn) SetRateLimiters(readLimiter *RateLimiter, writeLimiter *RateLimiter) {
+	c.readLimiter = readLimiter
+	c.writeLimiter = writeLimiter
+}
+
+func (c *BufferTcpConn) initBuffers(conn net.Conn) {
+	c.config = c.config.withDefaults()
+	c.conn = conn
+	c.sendBuffer = make([]byte, 0, c.config.SendFlushSize)
+	c.readBuffer = newRingBuffer(int(c.config.ReadChunkSize), int(c.config.MaxBufferSize))
+	c.readEOF = false
+	c.compression = nil
+	c.compressedIn = nil
+	c.pendingOut = nil
+	c.closed = 0
+	c.closeMutex = new(sync.Mutex)
+	c.firstErr = nil
+	c.closeReason = nil
+	c.touchRecv()
+	c.counters = new(connCounters)
+}
+
+// TCPAttach wraps an established connection, e.g. one dialed from a MemListener
+func (c *BufferTcpConn) TCPAttach(conn net.Conn) {
+	c.initBuffers(conn)
 }
 
 func (c *BufferTcpConn) TCPConnect(serverAddr string, serverPort uint16, timeOut float64) error {
-	client, err := net.DialTimeout("tcp", serverAddr+":"+strconv.Itoa(int(serverPort)),
-		time.Duration(timeOut*1000*1000*1000))
+	return c.tcpConnectContext(context.Background(), serverAddr, serverPort, timeOut)
+}
+
+func (c *BufferTcpConn) tcpConnectContext(ctx context.Context, serverAddr string, serverPort uint16, timeOut float64) error {
+	dialer := net.Dialer{Timeout: time.Duration(timeOut * 1000 * 1000 * 1000)}
+	client, err := dialer.DialContext(ctx, "tcp", serverAddr+":"+strconv.Itoa(int(serverPort)))
 	if err != nil {
 		return err
 	}
 
-	c.conn = client
-	c.sendBuffer = make([]byte, 0)
-	c.readBuffer = make([]byte, 0)
+	c.initBuffers(client)
+	return nil
+}
+
+// fillReadBuffer reads at most one chunk from the socket straight into the read buffer
+func (c *BufferTcpConn) fillReadBuffer() error {
+	if c.compressedIn != nil {
+		return c.fillCompressed()
+	}
+	return c.readSocket(c.readBuffer)
+}
+
+func (c *BufferTcpConn) readSocket(ring *ringBuffer) error {
+	err := ring.Grow(int(c.config.ReadChunkSize))
+	if err != nil && ring.Free() == 0 {
+		return err
+	}
+	buf := ring.WritableSlice()
+	if len(buf) > int(c.config.ReadChunkSize) {
+		buf = buf[0:c.config.ReadChunkSize]
+	}
+	if c.readLimiter != nil && !c.readLimiter.unlimited() && len(buf) > c.readLimiter.Burst() {
+		buf = buf[0:c.readLimiter.Burst()]
+	}
+	readStart := time.Now()
+	n, err := c.conn.Read(buf)
+	c.countRead(n, time.Since(readStart))
+	ring.Commit(n)
+	if n > 0 {
+		c.touchRecv()
+	}
+	if c.readLimiter != nil {
+		c.readLimiter.WaitN(n)
+	}
+	if err != nil {
+		err = classifyError(err)
+		c.noteError(err)
+		if err == io.EOF {
+			c.readEOF = true
+		} else {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *BufferTcpConn) checkReadSize(nRead uint32) error {
+	if nRead > c.config.MaxBufferSize {
+		return errors.New("read size exceeds max buffer size")
+	}
 	return nil
 }
 
 func (c *BufferTcpConn) TCPRead(nRead uint32) ([]byte, uint32, bool, error) {
-	remoteClose := false
+	err := c.checkReadSize(nRead)
+	if err != nil {
+		return nil, 0, c.readEOF, err
+	}
 	for {
-		if uint32(len(c.readBuffer)) >= nRead {
-			readBytes := []byte(c.readBuffer[0:nRead])
-			c.readBuffer = c.readBuffer[nRead:]
-			return readBytes, nRead, remoteClose, nil
-		} else if remoteClose == true {
-			readBytes := []byte(c.readBuffer)
-			c.readBuffer = make([]byte, 0)
-			return readBytes, uint32(len(readBytes)), remoteClose, nil
-		}
-
-		var buf [4096]byte
-		n, err := c.conn.Read(buf[0:])
+		if uint32(c.readBuffer.Len()) >= nRead {
+			readBytes := make([]byte, nRead)
+			c.readBuffer.Read(readBytes)
+			return readBytes, nRead, c.readEOF, nil
+		} else if c.readEOF == true {
+			readBytes := make([]byte, c.readBuffer.Len())
+			c.readBuffer.Read(readBytes)
+			return readBytes, uint32(len(readBytes)), c.readEOF, nil
+		}
+
+		err := c.fillReadBuffer()
 		if err != nil {
-			if err == io.EOF {
-				remoteClose = true
-			} else {
-				return nil, 0, remoteClose, err
+			return nil, 0, c.readEOF, err
+		}
+	}
+}
+
+// Peek returns the next nPeek bytes without consuming them.
+// The returned slice points into the read buffer and is only valid until the next read call.
+func (c *BufferTcpConn) Peek(nPeek uint32) ([]byte, error) {
+	err := c.checkReadSize(nPeek)
+	if err != nil {
+		return nil, err
+	}
+	for uint32(c.readBuffer.Len()) < nPeek {
+		if c.readEOF {
+			return c.readBuffer.Peek(int(nPeek)), io.EOF
+		}
+		err := c.fillReadBuffer()
+		if err != nil {
+			return c.readBuffer.Peek(int(nPeek)), err
+		}
+	}
+	return c.readBuffer.Peek(int(nPeek)), nil
+}
+
+// Discard skips the next nDiscard bytes, reading from the socket as necessary
+func (c *BufferTcpConn) Discard(nDiscard uint32) (uint32, error) {
+	discarded := uint32(0)
+	for discarded < nDiscard {
+		if c.readBuffer.Len() == 0 {
+			if c.readEOF {
+				return discarded, io.EOF
+			}
+			err := c.fillReadBuffer()
+			if err != nil {
+				return discarded, err
 			}
 		}
-		c.readBuffer = append(c.readBuffer, buf[0:n]...)
+		discarded = discarded + uint32(c.readBuffer.Discard(int(nDiscard-discarded)))
 	}
+	return discarded, nil
 }
 
-func (c *BufferTcpConn) TCPFlush() error {
-	n, err := c.conn.Write([]byte(c.sendBuffer))
+// checkAlive probes an idle connection for a pending close or error, waiting at most one millisecond
+func (c *BufferTcpConn) checkAlive() error {
+	if c.readBuffer.Len() > 0 {
+		return nil
+	}
+	if c.readEOF {
+		return io.EOF
+	}
+	err := c.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	if err != nil {
+		return err
+	}
+	err = c.fillReadBuffer()
+	_ = c.conn.SetReadDeadline(time.Time{})
 	if err != nil {
+		netErr, ok := err.(net.Error)
+		if ok && netErr.Timeout() {
+			return nil
+		}
 		return err
 	}
-	if n != len(c.sendBuffer) {
-		return errors.New("can not send completely")
+	if c.readEOF {
+		return io.EOF
 	}
-	c.sendBuffer = make([]byte, 0)
 	return nil
 }
 
+func (c *BufferTcpConn) TCPFlush() error {
+	c.countFlush()
+	if c.compressedIn != nil {
+		err := c.flushCompressed()
+		if err != nil {
+			return err
+		}
+		sent, err := c.writeChunks(c.pendingOut)
+		c.pendingOut = c.pendingOut[:copy(c.pendingOut, c.pendingOut[sent:])]
+		return err
+	}
+	sent, err := c.writeChunks(c.sendBuffer)
+	c.sendBuffer = c.sendBuffer[:copy(c.sendBuffer, c.sendBuffer[sent:])]
+	return err
+}
+
+// writeChunks writes buf to the socket in chunks allowed by the write limiter, returning the bytes sent
+func (c *BufferTcpConn) writeChunks(buf []byte) (int, error) {
+	sent := 0
+	for sent < len(buf) {
+		chunk := buf[sent:]
+		if c.writeLimiter != nil && !c.writeLimiter.unlimited() {
+			if len(chunk) > c.writeLimiter.Burst() {
+				chunk = chunk[0:c.writeLimiter.Burst()]
+			}
+			c.writeLimiter.WaitN(len(chunk))
+		}
+		writeStart := time.Now()
+		n, err := c.conn.Write(chunk)
+		c.countWrite(n, time.Since(writeStart))
+		sent = sent + n
+		if err != nil {
+			err = classifyError(err)
+			c.noteError(err)
+			return sent, err
+		}
+		if n != len(chunk) {
+			return sent, errors.New("can not send completely")
+		}
+	}
+	return sent, nil
+}
+
+// pendingSend reports whether bytes are waiting for a flush
+func (c *BufferTcpConn) pendingSend() bool {
+	return len(c.sendBuffer) > 0 || len(c.pendingOut) > 0
+}
+
 func (c *BufferTcpConn) TCPWrite(bytesWrite []byte) error {
 	c.sendBuffer = append(c.sendBuffer, bytesWrite...)
-	if len(c.sendBuffer) > 40960 {
+	if uint32(len(c.sendBuffer)) > c.config.SendFlushSize {
 		err := c.TCPFlush()
 		if err != nil {
 			return err
 		}
-		c.sendBuffer = make([]byte, 0)
 	}
 	return nil
 }
 
-func (c *BufferTcpConn) TCPDisConnect() error {
-	if len(c.sendBuffer) > 0 {
-		err := c.TCPFlush()
+// TCPWriteFrame buffers a frame with the same uint32 length prefix as serialization.PackBytes
+func (c *BufferTcpConn) TCPWriteFrame(payload []byte) error {
+	c.countFrameOut()
+	if c.compression != nil && c.compression.mode == CompressPerFrame {
+		encoded, err := c.encodeFrame(payload)
 		if err != nil {
 			return err
 		}
-		c.sendBuffer = make([]byte, 0)
+		return serialization.PackBytes(c, encoded)
 	}
-	_ = c.conn.Close()
-	return nil
+	return serialization.PackBytes(c, payload)
+}
+
+// TCPReadFrame reads a frame written by TCPWriteFrame, rejecting payloads larger than maxSize
+func (c *BufferTcpConn) TCPReadFrame(maxSize uint32) ([]byte, error) {
+	header, err := c.Peek(4)
+	if err != nil {
+		if err == io.EOF && len(header) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	frameSize := binary.LittleEndian.Uint32(header)
+	perFrame := c.compression != nil && c.compression.mode == CompressPerFrame
+	limit := uint64(maxSize)
+	if perFrame {
+		// the raw size prefix of a compressed frame
+		limit = limit + 4
+	}
+	if uint64(frameSize) > limit {
+		return nil, errors.New("frame size exceeds max size: " + strconv.Itoa(int(frameSize)))
+	}
+	_, _ = c.Discard(4)
+	payload, n, _, err := c.TCPRead(frameSize)
+	if err != nil {
+		return nil, err
+	}
+	if n != frameSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	c.countFrameIn()
+	if perFrame {
+		return c.decodeFrame(payload, maxSize)
+	}
+	return payload, nil
+}
+
+func (c *BufferTcpConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for c.readBuffer.Len() == 0 {
+		if c.readEOF {
+			return 0, io.EOF
+		}
+		err := c.fillReadBuffer()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.readBuffer.Read(p), nil
+}
+
+func (c *BufferTcpConn) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := c.Read(b[0:1])
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// Write buffers the bytes like TCPWrite, TCPFlush is still needed to send the tail
+func (c *BufferTcpConn) Write(p []byte) (int, error) {
+	err := c.TCPWrite(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *BufferTcpConn) Close() error {
+	return c.TCPDisConnect()
+}
+
+func (c *BufferTcpConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *BufferTcpConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *BufferTcpConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *BufferTcpConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *BufferTcpConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetConfig sets the config of the connections accepted afterwards, fields left 0 take their defaults
+func (c *TcpListener) SetConfig(config BufferTcpConfig) {
+	c.config = config.withDefaults()
+}
+
+// SetMaxConns limits the accepted connections not closed yet, extra connections are closed on accept.
+// 0 means unlimited.
+func (c *TcpListener) SetMaxConns(maxConns int64) {
+	c.maxConns = maxConns
+}
+
+// SetRateLimiters shares the limiters among all the connections accepted afterwards
+func (c *TcpListener) SetRateLimiters(readLimiter *RateLimiter, writeLimiter *RateLimiter) {
+	c.readLimiter = readLimiter
+	c.writeLimiter = writeLimiter
 }
 
 func (c *TcpListener) TCPListen(tcpAddr *net.TCPAddr) error {
@@ -101,26 +474,148 @@ func (c *TcpListener) TCPListen(tcpAddr *net.TCPAddr) error {
 	}
 	c.tcpAddr = tcpAddr
 	c.listener = listener
+	c.counters = new(listenerCounters)
+	c.initHandshakes()
 	return nil
 }
 
+// TCPListenOn accepts connections from an existing listener, e.g. a MemListener in tests
+func (c *TcpListener) TCPListenOn(listener net.Listener) {
+	c.tcpAddr = nil
+	c.listener = listener
+	c.counters = new(listenerCounters)
+	c.initHandshakes()
+}
+
+func (c *TcpListener) initHandshakes() {
+	c.handshaking = 0
+	c.handshakeOnce = new(sync.Once)
+	c.handshaken = make(chan *BufferTcpConn)
+	c.acceptDone = make(chan struct{})
+	c.acceptErr = nil
+}
+
+func (c *TcpListener) Addr() net.Addr {
+	if c.listener == nil {
+		return nil
+	}
+	return c.listener.Addr()
+}
+
 func (c *TcpListener) TCPAccept() (*BufferTcpConn, error) {
 	if c.listener == nil {
 		return nil, errors.New("invalid listener")
 	}
+	if c.proxyProtocol || c.compression != nil {
+		return c.acceptHandshaken()
+	}
 
+	for {
+		tcpConn, err := c.acceptConn()
+		if err != nil {
+			return nil, err
+		}
+		if tcpConn != nil {
+			c.admit(tcpConn)
+			return tcpConn, nil
+		}
+	}
+}
+
+// acceptConn accepts the next connection, nil when it was closed for exceeding the connection limit
+func (c *TcpListener) acceptConn() (*BufferTcpConn, error) {
 	conn, err := c.listener.Accept()
 	if err != nil {
+		atomic.AddUint64(&c.counters.acceptErrors, 1)
 		return nil, err
 	}
+	if c.maxConns > 0 && atomic.LoadInt64(&c.counters.active)+atomic.LoadInt64(&c.handshaking) >= c.maxConns {
+		atomic.AddUint64(&c.counters.rejected, 1)
+		_ = conn.Close()
+		return nil, nil
+	}
+	if c.keepAlivePeriod > 0 {
+		tcpConn, ok := conn.(*net.TCPConn)
+		if ok {
+			_ = tcpConn.SetKeepAlive(true)
+			_ = tcpConn.SetKeepAlivePeriod(c.keepAlivePeriod)
+		}
+	}
 
 	tcpConn := new(BufferTcpConn)
-	tcpConn.conn = conn
-	tcpConn.sendBuffer = make([]byte, 0)
-	tcpConn.readBuffer = make([]byte, 0)
+	tcpConn.SetConfig(c.config)
+	tcpConn.initBuffers(conn)
+	tcpConn.SetRateLimiters(c.readLimiter, c.writeLimiter)
 	return tcpConn, nil
 }
 
+// admit counts the connection as accepted
+func (c *TcpListener) admit(tcpConn *BufferTcpConn) {
+	atomic.AddUint64(&c.counters.accepted, 1)
+	atomic.AddInt64(&c.counters.active, 1)
+	tcpConn.listenerCounters = c.counters
+}
+
+// acceptHandshaken reads the PROXY header and compression handshake of every connection on a goroutine of its own,
+// so a client which is slow to send it does not hold back the others
+func (c *TcpListener) acceptHandshaken() (*BufferTcpConn, error) {
+	c.handshakeOnce.Do(func() {
+		go c.acceptLoop()
+	})
+	select {
+	case tcpConn := <-c.handshaken:
+		c.admit(tcpConn)
+		return tcpConn, nil
+	case <-c.acceptDone:
+		return nil, c.acceptErr
+	}
+}
+
+func (c *TcpListener) acceptLoop() {
+	for {
+		tcpConn, err := c.acceptConn()
+		if err != nil {
+			c.acceptErr = err
+			close(c.acceptDone)
+			return
+		}
+		if tcpConn == nil {
+			continue
+		}
+		atomic.AddInt64(&c.handshaking, 1)
+		go c.handshake(tcpConn)
+	}
+}
+
+func (c *TcpListener) handshake(tcpConn *BufferTcpConn) {
+	defer atomic.AddInt64(&c.handshaking, -1)
+	var err error
+	if c.proxyProtocol {
+		err = c.acceptProxyHeader(tcpConn)
+	}
+	if err == nil && c.compression != nil {
+		err = c.acceptCompression(tcpConn)
+	}
+	if err != nil {
+		atomic.AddUint64(&c.counters.rejected, 1)
+		_ = tcpConn.abort()
+		return
+	}
+	select {
+	case c.handshaken <- tcpConn:
+	case <-c.acceptDone:
+		_ = tcpConn.abort()
+	}
+}
+
+// handshakeDeadline bounds a handshake to timeOut seconds, DefaultHandshakeTimeOut when timeOut is not positive
+func handshakeDeadline(timeOut float64) time.Time {
+	if timeOut <= 0 {
+		timeOut = DefaultHandshakeTimeOut
+	}
+	return time.Now().Add(time.Duration(timeOut * 1000 * 1000 * 1000))
+}
+
 func (c *TcpListener) TCPListenClose() {
 	if c.listener != nil {
 		_ = c.listener.Close()
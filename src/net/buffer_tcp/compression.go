@@ -0,0 +1,398 @@
+package buffer_tcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	CompressNone    = uint8(0)
+	CompressDeflate = uint8(1)
+	CompressGzip    = uint8(2)
+)
+
+// compression modes, a listener may accept both by or-ing them
+const (
+	// every TCPFlush sends one compressed block, the reader sees the original byte stream
+	CompressPerFlush = uint8(1)
+	// TCPWriteFrame compresses each frame payload, plain TCPWrite bytes are sent as they are
+	CompressPerFrame = uint8(2)
+)
+
+var compressionMagic = []byte("BTZ1")
+
+var ErrCompressionHandshake = errors.New("invalid compression handshake")
+
+type CompressionConfig struct {
+	// algorithms in order of preference
+	Algorithms []uint8
+	// CompressPerFlush or CompressPerFrame, a listener may accept both
+	Mode uint8
+	// flate compression level, 0 means flate.DefaultCompression
+	Level int
+	// payloads shorter than this are sent stored, 0 compresses everything
+	MinSize uint32
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Reset(writer io.Writer)
+}
+
+type compressor struct {
+	algorithm uint8
+	mode      uint8
+	minSize   uint32
+	buffer    *bytes.Buffer
+	writer    resetWriter
+}
+
+func newCompressor(algorithm uint8, mode uint8, level int, minSize uint32) (*compressor, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	z := new(compressor)
+	z.algorithm = algorithm
+	z.mode = mode
+	z.minSize = minSize
+	z.buffer = new(bytes.Buffer)
+	var err error
+	switch algorithm {
+	case CompressDeflate:
+		z.writer, err = flate.NewWriter(z.buffer, level)
+	case CompressGzip:
+		z.writer, err = gzip.NewWriterLevel(z.buffer, level)
+	default:
+		err = errors.New("unsupported compression algorithm")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// compress returns the compressed form of p, or nil when it would not be smaller.
+// The result is only valid until the next call.
+func (z *compressor) compress(p []byte) ([]byte, error) {
+	if uint32(len(p)) < z.minSize {
+		return nil, nil
+	}
+	z.buffer.Reset()
+	z.writer.Reset(z.buffer)
+	_, err := z.writer.Write(p)
+	if err != nil {
+		return nil, err
+	}
+	err = z.writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	if z.buffer.Len() >= len(p) {
+		return nil, nil
+	}
+	return z.buffer.Bytes(), nil
+}
+
+func (z *compressor) decompress(data []byte, rawLen uint32) ([]byte, error) {
+	var reader io.Reader
+	switch z.algorithm {
+	case CompressDeflate:
+		reader = flate.NewReader(bytes.NewReader(data))
+	case CompressGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	}
+	raw := make([]byte, rawLen)
+	_, err := io.ReadFull(reader, raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// encode appends [storedLen][rawLen][stored] to dst, p is stored as is when compression does not pay off
+func (z *compressor) encode(dst []byte, p []byte) ([]byte, int, error) {
+	compressed, err := z.compress(p)
+	if err != nil {
+		return dst, 0, err
+	}
+	if compressed == nil {
+		compressed = p
+	}
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(compressed)))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(p)))
+	dst = append(dst, header[:]...)
+	return append(dst, compressed...), len(compressed), nil
+}
+
+// Compression returns the negotiated algorithm and mode, CompressNone if the stream is not compressed
+func (c *BufferTcpConn) Compression() (uint8, uint8) {
+	if c.compression == nil {
+		return CompressNone, 0
+	}
+	return c.compression.algorithm, c.compression.mode
+}
+
+func (c *BufferTcpConn) enableCompression(algorithm uint8, mode uint8, config CompressionConfig) error {
+	z, err := newCompressor(algorithm, mode, config.Level, config.MinSize)
+	if err != nil {
+		return err
+	}
+	c.compression = z
+	if mode == CompressPerFlush {
+		c.compressedIn = newRingBuffer(int(c.config.ReadChunkSize), int(c.config.MaxBufferSize)+8)
+		// anything read past the handshake already belongs to the compressed stream
+		_ = c.compressedIn.Write(c.readBuffer.Peek(c.readBuffer.Len()))
+		c.readBuffer.Reset()
+		return c.decodeBlocks()
+	}
+	return nil
+}
+
+// decodeBlocks moves the complete blocks received in per flush mode into the read buffer
+func (c *BufferTcpConn) decodeBlocks() error {
+	for c.compressedIn.Len() >= 8 {
+		header := c.compressedIn.Peek(8)
+		storedLen := binary.LittleEndian.Uint32(header[0:4])
+		rawLen := binary.LittleEndian.Uint32(header[4:8])
+		if storedLen > rawLen || rawLen > c.config.MaxBufferSize {
+			return errors.New("invalid compressed block")
+		}
+		if c.compressedIn.Len() < 8+int(storedLen) {
+			return nil
+		}
+		// the block waits until the reader made room for it
+		if c.readBuffer.limit-c.readBuffer.Len() < int(rawLen) {
+			return nil
+		}
+		raw := c.compressedIn.Peek(8 + int(storedLen))[8:]
+		if storedLen != rawLen {
+			var err error
+			raw, err = c.compression.decompress(raw, rawLen)
+			if err != nil {
+				return err
+			}
+		}
+		err := c.readBuffer.Write(raw)
+		if err != nil {
+			return err
+		}
+		c.compressedIn.Discard(8 + int(storedLen))
+		c.countDecompressed(int(rawLen), int(storedLen))
+	}
+	return nil
+}
+
+// fillCompressed reads one chunk of the compressed stream and decodes the blocks completed by it
+func (c *BufferTcpConn) fillCompressed() error {
+	// blocks held back while the read buffer was full come first
+	buffered := c.readBuffer.Len()
+	err := c.decodeBlocks()
+	if err != nil || c.readBuffer.Len() > buffered {
+		return err
+	}
+	err = c.readSocket(c.compressedIn)
+	if err != nil {
+		return err
+	}
+	err = c.decodeBlocks()
+	if err != nil {
+		return err
+	}
+	if c.readEOF && c.compressedIn.Len() > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// flushCompressed turns the send buffer into blocks appended to the pending compressed bytes.
+// A block holds at most SendFlushSize bytes and never more than MaxBufferSize, which the peer rejects.
+func (c *BufferTcpConn) flushCompressed() error {
+	blockSize := int(c.config.SendFlushSize)
+	if blockSize > int(c.config.MaxBufferSize) {
+		blockSize = int(c.config.MaxBufferSize)
+	}
+	encoded := 0
+	for encoded < len(c.sendBuffer) {
+		block := c.sendBuffer[encoded:]
+		if len(block) > blockSize {
+			block = block[0:blockSize]
+		}
+		var err error
+		var stored int
+		c.pendingOut, stored, err = c.compression.encode(c.pendingOut, block)
+		if err != nil {
+			c.sendBuffer = c.sendBuffer[:copy(c.sendBuffer, c.sendBuffer[encoded:])]
+			return err
+		}
+		c.countCompressed(len(block), stored)
+		encoded = encoded + len(block)
+	}
+	c.sendBuffer = c.sendBuffer[:0]
+	return nil
+}
+
+func (c *BufferTcpConn) encodeFrame(payload []byte) ([]byte, error) {
+	compressed, err := c.compression.compress(payload)
+	if err != nil {
+		return nil, err
+	}
+	if compressed == nil {
+		compressed = payload
+	}
+	encoded := make([]byte, 4+len(compressed))
+	binary.LittleEndian.PutUint32(encoded[0:4], uint32(len(payload)))
+	copy(encoded[4:], compressed)
+	c.countCompressed(len(payload), len(compressed))
+	return encoded, nil
+}
+
+func (c *BufferTcpConn) decodeFrame(encoded []byte, maxSize uint32) ([]byte, error) {
+	if len(encoded) < 4 {
+		return nil, errors.New("invalid compressed frame")
+	}
+	rawLen := binary.LittleEndian.Uint32(encoded[0:4])
+	if rawLen > maxSize {
+		return nil, errors.New("frame size exceeds max size: " + strconv.Itoa(int(rawLen)))
+	}
+	data := encoded[4:]
+	if uint32(len(data)) > rawLen {
+		return nil, errors.New("invalid compressed frame")
+	}
+	c.countDecompressed(int(rawLen), len(data))
+	if uint32(len(data)) == rawLen {
+		return data, nil
+	}
+	return c.compression.decompress(data, rawLen)
+}
+
+func checkCompressionMode(mode uint8) error {
+	if mode != CompressPerFlush && mode != CompressPerFrame {
+		return errors.New("compression mode must be CompressPerFlush or CompressPerFrame")
+	}
+	return nil
+}
+
+// NegotiateCompression offers the configured algorithms to a listener with compression enabled.
+// It must be called right after connecting, before any other byte is sent. The stream stays
+// uncompressed when the listener accepts none of the algorithms, see Compression.
+// A timeOut <= 0 waits DefaultHandshakeTimeOut for the reply.
+func (c *BufferTcpConn) NegotiateCompression(config CompressionConfig, timeOut float64) error {
+	err := checkCompressionMode(config.Mode)
+	if err != nil {
+		return err
+	}
+	if len(config.Algorithms) == 0 || len(config.Algorithms) > 255 {
+		return errors.New("compression algorithms must hold 1 to 255 entries")
+	}
+	offer := append([]byte{}, compressionMagic...)
+	offer = append(offer, config.Mode, uint8(len(config.Algorithms)))
+	offer = append(offer, config.Algorithms...)
+	err = c.TCPWrite(offer)
+	if err != nil {
+		return err
+	}
+	err = c.TCPFlush()
+	if err != nil {
+		return err
+	}
+
+	err = c.SetReadDeadline(handshakeDeadline(timeOut))
+	if err != nil {
+		return err
+	}
+	reply, err := c.Peek(uint32(len(compressionMagic) + 2))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(reply[0:len(compressionMagic)], compressionMagic) {
+		return ErrCompressionHandshake
+	}
+	algorithm := reply[len(compressionMagic)]
+	mode := reply[len(compressionMagic)+1]
+	_, _ = c.Discard(uint32(len(compressionMagic) + 2))
+	if algorithm != CompressNone {
+		if mode != config.Mode || bytes.IndexByte(config.Algorithms, algorithm) < 0 {
+			return ErrCompressionHandshake
+		}
+		err = c.enableCompression(algorithm, mode, config)
+		if err != nil {
+			return err
+		}
+	}
+	return c.SetReadDeadline(time.Time{})
+}
+
+// SetCompression makes TCPAccept expect a compression handshake from NegotiateCompression on every connection.
+// The first algorithm offered by the client which is also in config.Algorithms is chosen.
+// Connections which do not complete the handshake within handshakeTimeOut seconds are closed and counted as rejected,
+// 0 waits DefaultHandshakeTimeOut. Handshakes run on a goroutine per connection and do not delay other accepts.
+// A nil config disables the handshake.
+func (c *TcpListener) SetCompression(config *CompressionConfig, handshakeTimeOut float64) {
+	c.compression = config
+	c.compressionTimeOut = handshakeTimeOut
+}
+
+func (c *TcpListener) acceptCompression(tcpConn *BufferTcpConn) error {
+	err := tcpConn.SetReadDeadline(handshakeDeadline(c.compressionTimeOut))
+	if err != nil {
+		return err
+	}
+	header, err := tcpConn.Peek(uint32(len(compressionMagic) + 2))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header[0:len(compressionMagic)], compressionMagic) {
+		return ErrCompressionHandshake
+	}
+	mode := header[len(compressionMagic)]
+	count := uint32(header[len(compressionMagic)+1])
+	if checkCompressionMode(mode) != nil || count == 0 {
+		return ErrCompressionHandshake
+	}
+	_, _ = tcpConn.Discard(uint32(len(compressionMagic) + 2))
+	offered, _, _, err := tcpConn.TCPRead(count)
+	if err != nil {
+		return err
+	}
+	if uint32(len(offered)) != count {
+		return ErrCompressionHandshake
+	}
+
+	algorithm := CompressNone
+	if c.compression.Mode&mode != 0 {
+		for _, offer := range offered {
+			if offer != CompressNone && bytes.IndexByte(c.compression.Algorithms, offer) >= 0 {
+				algorithm = offer
+				break
+			}
+		}
+	}
+	reply := append([]byte{}, compressionMagic...)
+	reply = append(reply, algorithm, mode)
+	err = tcpConn.TCPWrite(reply)
+	if err != nil {
+		return err
+	}
+	err = tcpConn.TCPFlush()
+	if err != nil {
+		return err
+	}
+	if algorithm != CompressNone {
+		err = tcpConn.enableCompression(algorithm, mode, *c.compression)
+		if err != nil {
+			return err
+		}
+	}
+	return tcpConn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,244 @@
+package buffer_tcp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compressedPair(t *testing.T, serverConfig *CompressionConfig, clientConfig CompressionConfig) (*BufferTcpConn, *BufferTcpConn, *MemListener) {
+	memListener := new(MemListener)
+	memListener.Initialise("mem-compression")
+	listener := new(TcpListener)
+	listener.TCPListenOn(memListener)
+	listener.SetCompression(serverConfig, 1)
+
+	accepted := make(chan *BufferTcpConn)
+	go func() {
+		conn, err := listener.TCPAccept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	clientConn, err := memListener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := new(BufferTcpConn)
+	client.TCPAttach(clientConn)
+	err = client.NegotiateCompression(clientConfig, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, <-accepted, memListener
+}
+
+func TestCompressionPerFlush(t *testing.T) {
+	config := CompressionConfig{Algorithms: []uint8{CompressGzip, CompressDeflate}, Mode: CompressPerFlush}
+	client, server, memListener := compressedPair(t, &config, config)
+	defer memListener.Close()
+	algorithm, mode := server.Compression()
+	if algorithm != CompressGzip || mode != CompressPerFlush {
+		t.Fatal("unexpected negotiation", algorithm, mode)
+	}
+
+	payload := []byte(strings.Repeat("highly compressible payload ", 1000))
+	go func() {
+		_ = client.TCPWrite(payload)
+		_ = client.TCPWrite([]byte("tail"))
+		_ = client.TCPFlush()
+		_ = client.TCPWrite([]byte("x"))
+		_ = client.TCPDisConnect()
+	}()
+	received, n, _, err := server.TCPRead(uint32(len(payload) + 5))
+	if err != nil || n != uint32(len(payload)+5) {
+		t.Fatal("short read", n, err)
+	}
+	if !bytes.Equal(received[:len(payload)], payload) || string(received[len(payload):]) != "tailx" {
+		t.Fatal("payload mismatch")
+	}
+
+	stats := server.Stats()
+	if stats.UncompressedBytesIn != uint64(len(payload)+5) || stats.CompressedBytesIn >= stats.UncompressedBytesIn {
+		t.Fatal("unexpected compression stats", stats)
+	}
+	if stats.BytesIn >= uint64(len(payload)) || stats.CompressionRatio() < 10 {
+		t.Fatal("payload not compressed on the wire", stats.BytesIn, stats.CompressionRatio())
+	}
+}
+
+func TestCompressionPerFrame(t *testing.T) {
+	config := CompressionConfig{Algorithms: []uint8{CompressDeflate}, Mode: CompressPerFrame, MinSize: 64}
+	client, server, memListener := compressedPair(t, &config, config)
+	defer memListener.Close()
+
+	large := []byte(strings.Repeat("frame ", 500))
+	written := make(chan struct{})
+	go func() {
+		_ = client.TCPWriteFrame(large)
+		_ = client.TCPWriteFrame([]byte("small"))
+		_ = client.TCPWriteFrame([]byte{})
+		_ = client.TCPFlush()
+		close(written)
+	}()
+	frame, err := server.TCPReadFrame(uint32(len(large)))
+	if err != nil || !bytes.Equal(frame, large) {
+		t.Fatal("large frame mismatch", err)
+	}
+	frame, err = server.TCPReadFrame(16)
+	if err != nil || string(frame) != "small" {
+		t.Fatal("small frame mismatch", string(frame), err)
+	}
+	frame, err = server.TCPReadFrame(16)
+	if err != nil || len(frame) != 0 {
+		t.Fatal("empty frame mismatch", frame, err)
+	}
+	<-written
+	stats := client.Stats()
+	if stats.UncompressedBytesOut != uint64(len(large)+5) || stats.CompressedBytesOut >= uint64(len(large)) {
+		t.Fatal("unexpected compression stats", stats)
+	}
+
+	go func() {
+		_ = client.TCPWriteFrame(large)
+		_ = client.TCPFlush()
+	}()
+	_, err = server.TCPReadFrame(16)
+	if err == nil {
+		t.Fatal("expected max size error")
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	serverConfig := CompressionConfig{Algorithms: []uint8{CompressDeflate}, Mode: CompressPerFrame}
+	clientConfig := CompressionConfig{Algorithms: []uint8{CompressGzip}, Mode: CompressPerFrame}
+	client, server, memListener := compressedPair(t, &serverConfig, clientConfig)
+	defer memListener.Close()
+	if algorithm, _ := client.Compression(); algorithm != CompressNone {
+		t.Fatal("expected no common algorithm", algorithm)
+	}
+	go func() {
+		_ = client.TCPWriteFrame([]byte("plain"))
+		_ = client.TCPFlush()
+	}()
+	frame, err := server.TCPReadFrame(16)
+	if err != nil || string(frame) != "plain" {
+		t.Fatal("frame mismatch", string(frame), err)
+	}
+
+	clientConfig.Mode = CompressPerFlush
+	clientConfig.Algorithms = []uint8{CompressDeflate}
+	client, _, _ = compressedPair(t, &serverConfig, clientConfig)
+	if algorithm, _ := client.Compression(); algorithm != CompressNone {
+		t.Fatal("expected mode mismatch to disable compression", algorithm)
+	}
+
+	// the listeners of the pairs above keep accepting from their memory listener
+	garbageListener := new(MemListener)
+	garbageListener.Initialise("mem-compression-garbage")
+	defer garbageListener.Close()
+	listener := new(TcpListener)
+	listener.TCPListenOn(garbageListener)
+	listener.SetCompression(&serverConfig, 0.05)
+	accepted := make(chan *BufferTcpConn, 1)
+	go func() {
+		conn, err := listener.TCPAccept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	garbage, err := garbageListener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = garbage.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	time.Sleep(100 * time.Millisecond)
+	if listener.Stats().Rejected != 1 || len(accepted) != 0 {
+		t.Fatal("expected the handshake to be rejected", listener.Stats())
+	}
+}
+
+func TestCompressionPerFlushLarge(t *testing.T) {
+	config := CompressionConfig{Algorithms: []uint8{CompressDeflate}, Mode: CompressPerFlush}
+	client, server, memListener := compressedPair(t, &config, config)
+	defer memListener.Close()
+
+	// larger than MaxBufferSize, half of it does not compress
+	payload := make([]byte, DefaultMaxBufferSize+DefaultMaxBufferSize/4)
+	random := rand.New(rand.NewSource(1))
+	for i := range payload {
+		if i%2 == 0 {
+			payload[i] = byte(random.Intn(256))
+		} else {
+			payload[i] = 'x'
+		}
+	}
+	written := make(chan error, 1)
+	go func() {
+		err := client.TCPWrite(payload)
+		if err == nil {
+			err = client.TCPFlush()
+		}
+		written <- err
+	}()
+	received := make([]byte, len(payload))
+	_, err := io.ReadFull(server, received)
+	if err != nil || !bytes.Equal(received, payload) {
+		t.Fatal("payload mismatch", err)
+	}
+	err = <-written
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressionSlowClient(t *testing.T) {
+	memListener := new(MemListener)
+	memListener.Initialise("mem-compression-slow")
+	defer memListener.Close()
+	listener := new(TcpListener)
+	listener.TCPListenOn(memListener)
+	config := CompressionConfig{Algorithms: []uint8{CompressDeflate}, Mode: CompressPerFrame}
+	// 0 waits DefaultHandshakeTimeOut for the handshake
+	listener.SetCompression(&config, 0)
+
+	accepted := make(chan *BufferTcpConn, 1)
+	go func() {
+		conn, err := listener.TCPAccept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	silent, err := memListener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	clientConn, err := memListener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := new(BufferTcpConn)
+	client.TCPAttach(clientConn)
+	defer client.TCPDisConnect()
+	// 0 waits DefaultHandshakeTimeOut for the reply as well
+	err = client.NegotiateCompression(config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-accepted:
+		if algorithm, _ := conn.Compression(); algorithm != CompressDeflate {
+			t.Fatal("unexpected negotiation", algorithm)
+		}
+		_ = conn.TCPDisConnect()
+	case <-time.After(time.Second):
+		t.Fatal("the silent client held back the accept")
+	}
+}
@@ -0,0 +1,211 @@
+package buffer_tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Errors returned by the connection wrap the underlying error and match these with errors.Is
+var (
+	ErrRemoteClosed  = errors.New("connection closed by peer")
+	ErrConnReset     = errors.New("connection reset")
+	ErrTimeout       = errors.New("i/o timeout")
+	ErrClosedLocally = errors.New("connection closed locally")
+)
+
+var errHalfCloseUnsupported = errors.New("half close not supported by the underlying connection")
+
+type connError struct {
+	kind error
+	err  error
+}
+
+func (e *connError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+func (e *connError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *connError) Timeout() bool {
+	return e.kind == ErrTimeout
+}
+
+func (e *connError) Temporary() bool {
+	return e.kind == ErrTimeout
+}
+
+// classifyError wraps socket errors into ErrTimeout or ErrConnReset, other errors are returned as they are
+func classifyError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	var classified *connError
+	if errors.As(err, &classified) {
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &connError{kind: ErrTimeout, err: err}
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return &connError{kind: ErrConnReset, err: err}
+	}
+	return err
+}
+
+// noteError remembers the first failure of the connection as its close reason, timeouts are not failures
+func (c *BufferTcpConn) noteError(err error) {
+	if err == nil || errors.Is(err, ErrTimeout) {
+		return
+	}
+	if err == io.EOF {
+		err = &connError{kind: ErrRemoteClosed, err: io.EOF}
+	}
+	c.closeMutex.Lock()
+	if c.firstErr == nil {
+		c.firstErr = err
+	}
+	c.closeMutex.Unlock()
+}
+
+// TCPReadFull reads exactly nRead bytes. When the peer closes first the error matches ErrRemoteClosed
+// and wraps io.EOF, or io.ErrUnexpectedEOF along with the partial bytes if some were read.
+func (c *BufferTcpConn) TCPReadFull(nRead uint32) ([]byte, error) {
+	readBytes, n, _, err := c.TCPRead(nRead)
+	if err != nil {
+		return nil, err
+	}
+	if n == nRead {
+		return readBytes, nil
+	}
+	if n == 0 {
+		return nil, &connError{kind: ErrRemoteClosed, err: io.EOF}
+	}
+	return readBytes, &connError{kind: ErrRemoteClosed, err: io.ErrUnexpectedEOF}
+}
+
+// CloseWrite flushes the send buffer and shuts down the sending side,
+// the peer reads EOF while this side can still receive
+func (c *BufferTcpConn) CloseWrite() error {
+	if c.pendingSend() {
+		err := c.TCPFlush()
+		if err != nil {
+			return err
+		}
+	}
+	closer, ok := c.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errHalfCloseUnsupported
+	}
+	return classifyError(closer.CloseWrite())
+}
+
+// SetCloseTimeOut makes TCPDisConnect half close the connection and wait up to timeOut seconds
+// for the send buffer to drain and the peer to close its side, so unread inbound bytes do not
+// turn the close into a reset. 0 closes immediately after the flush.
+func (c *BufferTcpConn) SetCloseTimeOut(timeOut float64) {
+	c.closeTimeOut = timeOut
+}
+
+// OnClose registers a callback run once the socket is closed, with the reason returned by CloseReason
+func (c *BufferTcpConn) OnClose(onClose func(reason error)) {
+	c.closeMutex.Lock()
+	c.onClose = onClose
+	c.closeMutex.Unlock()
+}
+
+// CloseReason returns why the connection was closed, nil while it is open.
+// It is the first error seen on the connection, ErrHeartbeatTimeout when the heartbeat closed it,
+// or ErrClosedLocally for a close without an earlier error.
+func (c *BufferTcpConn) CloseReason() error {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	return c.closeReason
+}
+
+// drain discards inbound bytes after a half close until the peer closes its side or the deadline passes
+func (c *BufferTcpConn) drain() {
+	closer, ok := c.conn.(interface{ CloseWrite() error })
+	if !ok || closer.CloseWrite() != nil {
+		return
+	}
+	c.readBuffer.Reset()
+	for !c.readEOF {
+		if c.fillReadBuffer() != nil {
+			return
+		}
+		c.readBuffer.Reset()
+	}
+}
+
+// abort closes the socket without flushing, it is safe to call from any goroutine
+func (c *BufferTcpConn) abort() error {
+	return c.closeWithReason(nil)
+}
+
+// closeWithReason closes the socket once, a nil reason falls back to the first error seen
+func (c *BufferTcpConn) closeWithReason(reason error) error {
+	if !atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		return nil
+	}
+	if c.listenerCounters != nil {
+		atomic.AddInt64(&c.listenerCounters.active, -1)
+	}
+	err := c.conn.Close()
+
+	c.closeMutex.Lock()
+	if reason == nil {
+		reason = c.firstErr
+	}
+	if reason == nil {
+		reason = ErrClosedLocally
+	}
+	c.closeReason = reason
+	onClose := c.onClose
+	c.closeMutex.Unlock()
+
+	if onClose != nil {
+		onClose(reason)
+	}
+	return err
+}
+
+// TCPDisConnect flushes the send buffer and closes the connection, the socket is closed
+// even when the flush fails. Closing a closed connection does nothing.
+func (c *BufferTcpConn) TCPDisConnect() error {
+	if c.isClosed() {
+		return nil
+	}
+	if c.closeTimeOut > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(time.Duration(c.closeTimeOut * 1000 * 1000 * 1000)))
+	}
+	var err error
+	if c.pendingSend() {
+		err = c.TCPFlush()
+	}
+	// the EOF awaited by drain is no reason of its own
+	c.closeMutex.Lock()
+	reason := c.firstErr
+	c.closeMutex.Unlock()
+	if reason == nil {
+		reason = ErrClosedLocally
+	}
+	if err == nil && c.closeTimeOut > 0 {
+		c.drain()
+	}
+	closeErr := c.closeWithReason(reason)
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,130 @@
+package buffer_tcp
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCloseWrite(t *testing.T) {
+	client, server, memListener := memConnPair(t, nil)
+	defer memListener.Close()
+
+	_ = client.TCPWrite([]byte("request"))
+	err := client.CloseWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := server.TCPReadFull(7)
+	if err != nil || string(request) != "request" {
+		t.Fatal("unexpected request", string(request), err)
+	}
+	_, err = server.TCPReadFull(1)
+	if !errors.Is(err, ErrRemoteClosed) || !errors.Is(err, io.EOF) {
+		t.Fatal("expected remote close", err)
+	}
+
+	// the half closed side still receives the response
+	_ = server.TCPWrite([]byte("response"))
+	_ = server.TCPDisConnect()
+	response, err := client.TCPReadFull(10)
+	if string(response) != "response" || !errors.Is(err, ErrRemoteClosed) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatal("expected the partial response", string(response), err)
+	}
+	if !errors.Is(server.CloseReason(), ErrRemoteClosed) {
+		t.Fatal("unexpected close reason", server.CloseReason())
+	}
+}
+
+func TestCloseErrors(t *testing.T) {
+	client, server, memListener := memConnPair(t, &FaultConfig{ResetAfterBytes: 4})
+	defer memListener.Close()
+
+	_ = server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := server.TCPReadFull(1)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatal("expected timeout", err)
+	}
+	_ = server.SetReadDeadline(time.Time{})
+
+	_ = client.TCPWrite([]byte("0123456789"))
+	_ = client.TCPFlush()
+	_, err = server.TCPReadFull(10)
+	if !errors.Is(err, ErrConnReset) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("expected reset", err)
+	}
+
+	reasons := make(chan error, 2)
+	server.OnClose(func(reason error) {
+		reasons <- reason
+	})
+	if server.CloseReason() != nil {
+		t.Fatal("expected no close reason while open")
+	}
+	_ = server.TCPDisConnect()
+	_ = server.TCPDisConnect()
+	if len(reasons) != 1 || !errors.Is(<-reasons, ErrConnReset) {
+		t.Fatal("expected a single reset close reason")
+	}
+	if atomic.LoadInt64(&server.listenerCounters.active) != 0 {
+		t.Fatal("expected no active connection")
+	}
+}
+
+func TestCloseFlushFailure(t *testing.T) {
+	client, server, memListener := memConnPair(t, nil)
+	defer memListener.Close()
+
+	client.conn.(*memConn).Reset()
+	_ = server.TCPWrite([]byte("lost"))
+	err := server.TCPDisConnect()
+	if !errors.Is(err, ErrConnReset) {
+		t.Fatal("expected the flush error", err)
+	}
+	if !server.isClosed() || atomic.LoadInt64(&server.listenerCounters.active) != 0 {
+		t.Fatal("expected the connection closed after a failed flush")
+	}
+	if !errors.Is(server.CloseReason(), ErrConnReset) {
+		t.Fatal("unexpected close reason", server.CloseReason())
+	}
+}
+
+func TestCloseTimeOut(t *testing.T) {
+	client, server, memListener := memConnPair(t, nil)
+	defer memListener.Close()
+
+	server.SetCloseTimeOut(1)
+	received := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		_ = client.TCPDisConnect()
+		received <- data
+	}()
+	_ = client.TCPWrite([]byte("unread by the server"))
+	_ = client.TCPFlush()
+	_ = server.TCPWrite([]byte("goodbye"))
+	start := time.Now()
+	err := server.TCPDisConnect()
+	if err != nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatal("expected a graceful close", err, time.Since(start))
+	}
+	if string(<-received) != "goodbye" {
+		t.Fatal("response lost")
+	}
+	if server.CloseReason() != ErrClosedLocally {
+		t.Fatal("unexpected close reason", server.CloseReason())
+	}
+
+	client, server, _ = memConnPair(t, nil)
+	server.SetCloseTimeOut(0.05)
+	start = time.Now()
+	err = server.TCPDisConnect()
+	if err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected close to wait for the peer", err, time.Since(start))
+	}
+	_ = client.TCPDisConnect()
+}
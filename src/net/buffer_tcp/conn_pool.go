@@ -0,0 +1,375 @@
+package buffer_tcp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultPoolMaxIdle = 2
+
+type ConnPoolConfig struct {
+	// idle connections kept for reuse, 0 means DefaultPoolMaxIdle
+	MaxIdle int
+	// connections opened at the same time, 0 means unlimited
+	MaxOpen int
+	// idle connections older than this are closed, 0 disables eviction
+	IdleTimeout time.Duration
+	// run on idle connections before handing them out, nil uses a non-blocking liveness probe
+	HealthCheck func(*BufferTcpConn) error
+	ConnConfig  BufferTcpConfig
+	// negotiated on every new connection when not nil
+	Compression *CompressionConfig
+}
+
+type ConnPoolStats struct {
+	OpenCount         int
+	IdleCount         int
+	InUseCount        int
+	WaitCount         uint64
+	WaitDuration      time.Duration
+	HitCount          uint64
+	MissCount         uint64
+	TimeoutCount      uint64
+	ClosedMaxIdle     uint64
+	ClosedIdleTimeout uint64
+	ClosedUnhealthy   uint64
+	ClosedBroken      uint64
+}
+
+type idleConn struct {
+	conn  *BufferTcpConn
+	since time.Time
+}
+
+// connRequest hands a connection to a waiting Get, a nil conn without error grants a dial slot
+type connRequest struct {
+	conn *BufferTcpConn
+	err  error
+}
+
+type ConnPool struct {
+	mutex      *sync.Mutex
+	serverAddr string
+	serverPort uint16
+	timeOut    float64
+	config     ConnPoolConfig
+	idle       []idleConn
+	numOpen    int
+	waiters    []chan connRequest
+	closed     bool
+	stats      ConnPoolStats
+	quit       chan struct{}
+}
+
+func (p *ConnPool) Initialise(serverAddr string, serverPort uint16, timeOut float64, config ConnPoolConfig) {
+	if config.MaxIdle == 0 {
+		config.MaxIdle = DefaultPoolMaxIdle
+	}
+	if config.HealthCheck == nil {
+		config.HealthCheck = func(c *BufferTcpConn) error {
+			return c.checkAlive()
+		}
+	}
+	p.mutex = new(sync.Mutex)
+	p.serverAddr = serverAddr
+	p.serverPort = serverPort
+	p.timeOut = timeOut
+	p.config = config
+	p.idle = make([]idleConn, 0)
+	p.numOpen = 0
+	p.waiters = make([]chan connRequest, 0)
+	p.closed = false
+	p.stats = ConnPoolStats{}
+	p.quit = make(chan struct{})
+	if config.IdleTimeout > 0 {
+		go p.evictLoop(p.quit)
+	}
+}
+
+func (p *ConnPool) evictLoop(quit chan struct{}) {
+	interval := p.config.IdleTimeout / 2
+	if interval <= 0 {
+		interval = p.config.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			p.EvictIdle()
+		}
+	}
+}
+
+// EvictIdle closes the idle connections which exceeded IdleTimeout
+func (p *ConnPool) EvictIdle() {
+	if p.config.IdleTimeout <= 0 {
+		return
+	}
+	expired := make([]*BufferTcpConn, 0)
+	p.mutex.Lock()
+	kept := p.idle[:0]
+	for _, ic := range p.idle {
+		if time.Since(ic.since) > p.config.IdleTimeout {
+			expired = append(expired, ic.conn)
+			p.stats.ClosedIdleTimeout++
+			p.releaseSlotLocked()
+		} else {
+			kept = append(kept, ic)
+		}
+	}
+	p.idle = kept
+	p.mutex.Unlock()
+
+	for _, conn := range expired {
+		_ = conn.Close()
+	}
+}
+
+// releaseSlotLocked gives the slot of a closed connection to the first waiter, if any
+func (p *ConnPool) releaseSlotLocked() {
+	if len(p.waiters) > 0 {
+		req := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		req <- connRequest{}
+	} else {
+		p.numOpen--
+	}
+}
+
+func (p *ConnPool) removeWaiterLocked(req chan connRequest) bool {
+	for i, waiter := range p.waiters {
+		if waiter == req {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ConnPool) dial(ctx context.Context) (*BufferTcpConn, error) {
+	conn := new(BufferTcpConn)
+	conn.SetConfig(p.config.ConnConfig)
+	err := conn.tcpConnectContext(ctx, p.serverAddr, p.serverPort, p.timeOut)
+	if err == nil && p.config.Compression != nil {
+		err = conn.NegotiateCompression(*p.config.Compression, p.timeOut)
+		if err != nil {
+			_ = conn.abort()
+		}
+	}
+	if err != nil {
+		p.mutex.Lock()
+		p.releaseSlotLocked()
+		p.mutex.Unlock()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *ConnPool) Get(ctx context.Context) (*BufferTcpConn, error) {
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, errors.New("conn pool: closed")
+		}
+
+		if len(p.idle) > 0 {
+			ic := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+			if p.config.IdleTimeout > 0 && time.Since(ic.since) > p.config.IdleTimeout {
+				p.stats.ClosedIdleTimeout++
+				p.releaseSlotLocked()
+				p.mutex.Unlock()
+				_ = ic.conn.Close()
+				continue
+			}
+			p.mutex.Unlock()
+
+			err := p.config.HealthCheck(ic.conn)
+			if err != nil {
+				p.mutex.Lock()
+				p.stats.ClosedUnhealthy++
+				p.releaseSlotLocked()
+				p.mutex.Unlock()
+				_ = ic.conn.Close()
+				continue
+			}
+			p.mutex.Lock()
+			p.stats.HitCount++
+			p.mutex.Unlock()
+			return ic.conn, nil
+		}
+
+		if p.config.MaxOpen <= 0 || p.numOpen < p.config.MaxOpen {
+			p.numOpen++
+			p.stats.MissCount++
+			p.mutex.Unlock()
+			return p.dial(ctx)
+		}
+
+		req := make(chan connRequest, 1)
+		p.waiters = append(p.waiters, req)
+		p.stats.WaitCount++
+		p.mutex.Unlock()
+
+		waitStart := time.Now()
+		select {
+		case <-ctx.Done():
+			p.mutex.Lock()
+			removed := p.removeWaiterLocked(req)
+			p.stats.TimeoutCount++
+			p.stats.WaitDuration += time.Since(waitStart)
+			p.mutex.Unlock()
+			if !removed {
+				// lost the race against a concurrent Put, give back what it handed over
+				handed := <-req
+				if handed.conn != nil {
+					p.Put(handed.conn, false)
+				} else if handed.err == nil {
+					p.mutex.Lock()
+					p.releaseSlotLocked()
+					p.mutex.Unlock()
+				}
+			}
+			return nil, ctx.Err()
+		case handed := <-req:
+			p.mutex.Lock()
+			p.stats.WaitDuration += time.Since(waitStart)
+			if handed.err != nil {
+				p.mutex.Unlock()
+				return nil, handed.err
+			}
+			if handed.conn == nil {
+				p.stats.MissCount++
+				p.mutex.Unlock()
+				return p.dial(ctx)
+			}
+			p.stats.HitCount++
+			p.mutex.Unlock()
+			return handed.conn, nil
+		}
+	}
+}
+
+// Put returns a connection got from Get, broken connections are closed instead of reused
+func (p *ConnPool) Put(conn *BufferTcpConn, broken bool) {
+	if !broken && conn.pendingSend() {
+		broken = conn.TCPFlush() != nil
+	}
+
+	p.mutex.Lock()
+	if broken || p.closed {
+		if broken {
+			p.stats.ClosedBroken++
+		}
+		p.releaseSlotLocked()
+		p.mutex.Unlock()
+		_ = conn.Close()
+		return
+	}
+
+	if len(p.waiters) > 0 {
+		req := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		req <- connRequest{conn: conn}
+		p.mutex.Unlock()
+		return
+	}
+
+	if len(p.idle) < p.config.MaxIdle {
+		p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+		p.mutex.Unlock()
+		return
+	}
+
+	p.stats.ClosedMaxIdle++
+	p.releaseSlotLocked()
+	p.mutex.Unlock()
+	_ = conn.Close()
+}
+
+func (p *ConnPool) Stats() ConnPoolStats {
+	p.mutex.Lock()
+	stats := p.stats
+	stats.OpenCount = p.numOpen
+	stats.IdleCount = len(p.idle)
+	stats.InUseCount = p.numOpen - len(p.idle)
+	p.mutex.Unlock()
+	return stats
+}
+
+// Destroy closes the idle connections, connections in use are closed when they are put back
+func (p *ConnPool) Destroy() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.quit)
+	idle := p.idle
+	p.idle = make([]idleConn, 0)
+	p.numOpen = p.numOpen - len(idle)
+	for _, req := range p.waiters {
+		req <- connRequest{err: errors.New("conn pool: closed")}
+	}
+	p.waiters = make([]chan connRequest, 0)
+	p.mutex.Unlock()
+
+	for _, ic := range idle {
+		_ = ic.conn.Close()
+	}
+}
+
+type ConnPoolGroup struct {
+	mutex   *sync.Mutex
+	timeOut float64
+	config  ConnPoolConfig
+	pools   map[string]*ConnPool
+}
+
+func (g *ConnPoolGroup) Initialise(timeOut float64, config ConnPoolConfig) {
+	g.mutex = new(sync.Mutex)
+	g.timeOut = timeOut
+	g.config = config
+	g.pools = make(map[string]*ConnPool)
+}
+
+// GetPool returns the pool of the address, creating it on first use
+func (g *ConnPoolGroup) GetPool(serverAddr string, serverPort uint16) *ConnPool {
+	key := serverAddr + ":" + strconv.Itoa(int(serverPort))
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	pool, ok := g.pools[key]
+	if !ok {
+		pool = new(ConnPool)
+		pool.Initialise(serverAddr, serverPort, g.timeOut, g.config)
+		g.pools[key] = pool
+	}
+	return pool
+}
+
+func (g *ConnPoolGroup) Stats() map[string]ConnPoolStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	stats := make(map[string]ConnPoolStats)
+	for key, pool := range g.pools {
+		stats[key] = pool.Stats()
+	}
+	return stats
+}
+
+func (g *ConnPoolGroup) Destroy() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, pool := range g.pools {
+		pool.Destroy()
+	}
+	g.pools = make(map[string]*ConnPool)
+}
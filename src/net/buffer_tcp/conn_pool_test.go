@@ -0,0 +1,128 @@
+package buffer_tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func startPoolServer(t *testing.T) (*TcpListener, uint16, chan *BufferTcpConn) {
+	listener := new(TcpListener)
+	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan *BufferTcpConn, 16)
+	go func() {
+		for {
+			conn, err := listener.TCPAccept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return listener, uint16(listener.Addr().(*net.TCPAddr).Port), accepted
+}
+
+func TestConnPoolLimits(t *testing.T) {
+	listener, port, _ := startPoolServer(t)
+	defer listener.TCPListenClose()
+
+	pool := new(ConnPool)
+	pool.Initialise("127.0.0.1", port, 1, ConnPoolConfig{MaxIdle: 1, MaxOpen: 2})
+	defer pool.Destroy()
+
+	c1, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = pool.Get(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected deadline exceeded", err)
+	}
+
+	got := make(chan *BufferTcpConn)
+	go func() {
+		c, _ := pool.Get(context.Background())
+		got <- c
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pool.Put(c1, false)
+	c3 := <-got
+	if c3 != c1 {
+		t.Fatal("expected the released connection to be handed over")
+	}
+
+	pool.Put(c2, true)
+	pool.Put(c3, false)
+	stats := pool.Stats()
+	if stats.OpenCount != 1 || stats.IdleCount != 1 || stats.InUseCount != 0 {
+		t.Fatal("unexpected stats", stats)
+	}
+	if stats.ClosedBroken != 1 || stats.TimeoutCount != 1 || stats.WaitCount != 2 || stats.MissCount != 2 {
+		t.Fatal("unexpected counters", stats)
+	}
+}
+
+func TestConnPoolHealthCheck(t *testing.T) {
+	listener, port, accepted := startPoolServer(t)
+	defer listener.TCPListenClose()
+
+	pool := new(ConnPool)
+	pool.Initialise("127.0.0.1", port, 1, ConnPoolConfig{})
+	defer pool.Destroy()
+
+	c1, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c1, false)
+	serverSide := <-accepted
+	_ = serverSide.TCPDisConnect()
+	time.Sleep(20 * time.Millisecond)
+
+	c2, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 == c1 {
+		t.Fatal("expected the closed connection to be replaced")
+	}
+	pool.Put(c2, false)
+	if pool.Stats().ClosedUnhealthy != 1 {
+		t.Fatal("unexpected stats", pool.Stats())
+	}
+}
+
+func TestConnPoolIdleEviction(t *testing.T) {
+	listener, port, _ := startPoolServer(t)
+	defer listener.TCPListenClose()
+
+	group := new(ConnPoolGroup)
+	group.Initialise(1, ConnPoolConfig{IdleTimeout: 20 * time.Millisecond})
+	defer group.Destroy()
+
+	pool := group.GetPool("127.0.0.1", port)
+	if group.GetPool("127.0.0.1", port) != pool {
+		t.Fatal("expected the same pool for the same address")
+	}
+	c, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c, false)
+	time.Sleep(100 * time.Millisecond)
+	stats := pool.Stats()
+	if stats.IdleCount != 0 || stats.OpenCount != 0 || stats.ClosedIdleTimeout != 1 {
+		t.Fatal("unexpected stats", stats)
+	}
+}
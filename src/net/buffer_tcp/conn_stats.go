@@ -0,0 +1,319 @@
+package buffer_tcp
+
+import (
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/net/metrics"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connCounters struct {
+	bytesIn    uint64
+	bytesOut   uint64
+	framesIn   uint64
+	framesOut  uint64
+	flushCount uint64
+	readCount  uint64
+	readNanos  uint64
+	writeCount uint64
+	writeNanos uint64
+
+	uncompressedIn  uint64
+	compressedIn    uint64
+	uncompressedOut uint64
+	compressedOut   uint64
+}
+
+type listenerCounters struct {
+	accepted     uint64
+	rejected     uint64
+	acceptErrors uint64
+	active       int64
+	conns        connCounters
+}
+
+type ConnStats struct {
+	BytesIn      uint64
+	BytesOut     uint64
+	FramesIn     uint64
+	FramesOut    uint64
+	FlushCount   uint64
+	ReadCount    uint64
+	ReadLatency  time.Duration
+	WriteCount   uint64
+	WriteLatency time.Duration
+	// payload bytes before compression and after it, payloads sent stored count on both sides
+	UncompressedBytesIn  uint64
+	CompressedBytesIn    uint64
+	UncompressedBytesOut uint64
+	CompressedBytesOut   uint64
+}
+
+// CompressionRatio returns uncompressed bytes per compressed byte in both directions, 0 without compression
+func (s ConnStats) CompressionRatio() float64 {
+	compressed := s.CompressedBytesIn + s.CompressedBytesOut
+	if compressed == 0 {
+		return 0
+	}
+	return float64(s.UncompressedBytesIn+s.UncompressedBytesOut) / float64(compressed)
+}
+
+type ListenerStats struct {
+	Accepted     uint64
+	Rejected     uint64
+	AcceptErrors uint64
+	Active       int64
+	// totals of the connections accepted by the listener
+	Conns ConnStats
+}
+
+func (s *connCounters) snapshot() ConnStats {
+	return ConnStats{
+		BytesIn:      atomic.LoadUint64(&s.bytesIn),
+		BytesOut:     atomic.LoadUint64(&s.bytesOut),
+		FramesIn:     atomic.LoadUint64(&s.framesIn),
+		FramesOut:    atomic.LoadUint64(&s.framesOut),
+		FlushCount:   atomic.LoadUint64(&s.flushCount),
+		ReadCount:    atomic.LoadUint64(&s.readCount),
+		ReadLatency:  time.Duration(atomic.LoadUint64(&s.readNanos)),
+		WriteCount:   atomic.LoadUint64(&s.writeCount),
+		WriteLatency: time.Duration(atomic.LoadUint64(&s.writeNanos)),
+
+		UncompressedBytesIn:  atomic.LoadUint64(&s.uncompressedIn),
+		CompressedBytesIn:    atomic.LoadUint64(&s.compressedIn),
+		UncompressedBytesOut: atomic.LoadUint64(&s.uncompressedOut),
+		CompressedBytesOut:   atomic.LoadUint64(&s.compressedOut),
+	}
+}
+
+func (c *BufferTcpConn) eachCounters(f func(*connCounters)) {
+	if c.counters != nil {
+		f(c.counters)
+	}
+	if c.listenerCounters != nil {
+		f(&c.listenerCounters.conns)
+	}
+}
+
+func (c *BufferTcpConn) countRead(n int, elapsed time.Duration) {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.bytesIn, uint64(n))
+		atomic.AddUint64(&s.readCount, 1)
+		atomic.AddUint64(&s.readNanos, uint64(elapsed))
+	})
+}
+
+func (c *BufferTcpConn) countWrite(n int, elapsed time.Duration) {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.bytesOut, uint64(n))
+		atomic.AddUint64(&s.writeCount, 1)
+		atomic.AddUint64(&s.writeNanos, uint64(elapsed))
+	})
+}
+
+func (c *BufferTcpConn) countFlush() {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.flushCount, 1)
+	})
+}
+
+func (c *BufferTcpConn) countFrameIn() {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.framesIn, 1)
+	})
+}
+
+func (c *BufferTcpConn) countFrameOut() {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.framesOut, 1)
+	})
+}
+
+func (c *BufferTcpConn) countCompressed(raw int, compressed int) {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.uncompressedOut, uint64(raw))
+		atomic.AddUint64(&s.compressedOut, uint64(compressed))
+	})
+}
+
+func (c *BufferTcpConn) countDecompressed(raw int, compressed int) {
+	c.eachCounters(func(s *connCounters) {
+		atomic.AddUint64(&s.uncompressedIn, uint64(raw))
+		atomic.AddUint64(&s.compressedIn, uint64(compressed))
+	})
+}
+
+func (c *BufferTcpConn) Stats() ConnStats {
+	if c.counters == nil {
+		return ConnStats{}
+	}
+	return c.counters.snapshot()
+}
+
+func (c *TcpListener) Stats() ListenerStats {
+	if c.counters == nil {
+		return ListenerStats{}
+	}
+	return ListenerStats{
+		Accepted:     atomic.LoadUint64(&c.counters.accepted),
+		Rejected:     atomic.LoadUint64(&c.counters.rejected),
+		AcceptErrors: atomic.LoadUint64(&c.counters.acceptErrors),
+		Active:       atomic.LoadInt64(&c.counters.active),
+		Conns:        c.counters.conns.snapshot(),
+	}
+}
+
+// PrometheusExporter renders the stats of registered listeners and connections
+// in the Prometheus text exposition format
+type PrometheusExporter struct {
+	mutex     *sync.RWMutex
+	prefix    string
+	listeners map[string]*TcpListener
+	conns     map[string]*BufferTcpConn
+}
+
+func (e *PrometheusExporter) Initialise(prefix string) {
+	e.mutex = new(sync.RWMutex)
+	e.prefix = prefix
+	e.listeners = make(map[string]*TcpListener)
+	e.conns = make(map[string]*BufferTcpConn)
+}
+
+func (e *PrometheusExporter) RegisterListener(name string, listener *TcpListener) {
+	e.mutex.Lock()
+	e.listeners[name] = listener
+	e.mutex.Unlock()
+}
+
+func (e *PrometheusExporter) UnRegisterListener(name string) {
+	e.mutex.Lock()
+	delete(e.listeners, name)
+	e.mutex.Unlock()
+}
+
+func (e *PrometheusExporter) RegisterConn(name string, conn *BufferTcpConn) {
+	e.mutex.Lock()
+	e.conns[name] = conn
+	e.mutex.Unlock()
+}
+
+func (e *PrometheusExporter) UnRegisterConn(name string) {
+	e.mutex.Lock()
+	delete(e.conns, name)
+	e.mutex.Unlock()
+}
+
+type promSample struct {
+	label string
+	name  string
+	value float64
+}
+
+type promFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []promSample
+}
+
+func sortedKeys(names []string) []string {
+	sort.Strings(names)
+	return names
+}
+
+func connFamilies(prefix string) []*promFamily {
+	return []*promFamily{
+		{name: prefix + "_bytes_in_total", help: "Bytes read from the socket.", kind: "counter"},
+		{name: prefix + "_bytes_out_total", help: "Bytes written to the socket.", kind: "counter"},
+		{name: prefix + "_frames_in_total", help: "Frames read.", kind: "counter"},
+		{name: prefix + "_frames_out_total", help: "Frames written.", kind: "counter"},
+		{name: prefix + "_flushes_total", help: "Send buffer flushes.", kind: "counter"},
+		{name: prefix + "_reads_total", help: "Socket read calls.", kind: "counter"},
+		{name: prefix + "_read_seconds_total", help: "Time spent in socket reads.", kind: "counter"},
+		{name: prefix + "_writes_total", help: "Socket write calls.", kind: "counter"},
+		{name: prefix + "_write_seconds_total", help: "Time spent in socket writes.", kind: "counter"},
+		{name: prefix + "_uncompressed_bytes_in_total", help: "Payload bytes after decompression.", kind: "counter"},
+		{name: prefix + "_compressed_bytes_in_total", help: "Payload bytes received compressed.", kind: "counter"},
+		{name: prefix + "_uncompressed_bytes_out_total", help: "Payload bytes before compression.", kind: "counter"},
+		{name: prefix + "_compressed_bytes_out_total", help: "Payload bytes sent compressed.", kind: "counter"},
+	}
+}
+
+func addConnSamples(families []*promFamily, label string, name string, stats ConnStats) {
+	values := []float64{
+		float64(stats.BytesIn), float64(stats.BytesOut),
+		float64(stats.FramesIn), float64(stats.FramesOut),
+		float64(stats.FlushCount),
+		float64(stats.ReadCount), stats.ReadLatency.Seconds(),
+		float64(stats.WriteCount), stats.WriteLatency.Seconds(),
+		float64(stats.UncompressedBytesIn), float64(stats.CompressedBytesIn),
+		float64(stats.UncompressedBytesOut), float64(stats.CompressedBytesOut),
+	}
+	for i, family := range families {
+		family.samples = append(family.samples, promSample{label: label, name: name, value: values[i]})
+	}
+}
+
+// WriteTo writes all registered stats, metric families are emitted once with a series per object.
+// The connection totals of listeners are named <prefix>_listener_*, so every family has a single label name.
+func (e *PrometheusExporter) WriteTo(writer io.Writer) (int64, error) {
+	listenerFamilies := connFamilies(e.prefix + "_listener")
+	families := connFamilies(e.prefix)
+	accepted := &promFamily{name: e.prefix + "_accepted_total", help: "Accepted connections.", kind: "counter"}
+	rejected := &promFamily{name: e.prefix + "_rejected_total", help: "Connections rejected by the listener.", kind: "counter"}
+	acceptErrors := &promFamily{name: e.prefix + "_accept_errors_total", help: "Failed accept calls.", kind: "counter"}
+	active := &promFamily{name: e.prefix + "_active_connections", help: "Accepted connections not closed yet.", kind: "gauge"}
+
+	e.mutex.RLock()
+	listenerNames := make([]string, 0, len(e.listeners))
+	for name := range e.listeners {
+		listenerNames = append(listenerNames, name)
+	}
+	for _, name := range sortedKeys(listenerNames) {
+		stats := e.listeners[name].Stats()
+		accepted.samples = append(accepted.samples, promSample{label: "listener", name: name, value: float64(stats.Accepted)})
+		rejected.samples = append(rejected.samples, promSample{label: "listener", name: name, value: float64(stats.Rejected)})
+		acceptErrors.samples = append(acceptErrors.samples, promSample{label: "listener", name: name, value: float64(stats.AcceptErrors)})
+		active.samples = append(active.samples, promSample{label: "listener", name: name, value: float64(stats.Active)})
+		addConnSamples(listenerFamilies, "listener", name, stats.Conns)
+	}
+	connNames := make([]string, 0, len(e.conns))
+	for name := range e.conns {
+		connNames = append(connNames, name)
+	}
+	for _, name := range sortedKeys(connNames) {
+		addConnSamples(families, "conn", name, e.conns[name].Stats())
+	}
+	e.mutex.RUnlock()
+
+	families = append(append(families, listenerFamilies...), accepted, rejected, acceptErrors, active)
+	written := int64(0)
+	for _, family := range families {
+		if len(family.samples) == 0 {
+			continue
+		}
+		n, err := fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		for _, sample := range family.samples {
+			n, err = fmt.Fprintf(writer, "%s{%s=\"%s\"} %v\n", family.name, sample.label,
+				metrics.EscapeLabelValue(sample.name), sample.value)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = e.WriteTo(w)
+}
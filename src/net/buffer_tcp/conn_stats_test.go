@@ -0,0 +1,102 @@
+package buffer_tcp
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnStats(t *testing.T) {
+	listener := new(TcpListener)
+	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.TCPListenClose()
+	listener.SetMaxConns(1)
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	accepted := make(chan *BufferTcpConn, 2)
+	go func() {
+		for {
+			conn, err := listener.TCPAccept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	client := new(BufferTcpConn)
+	err = client.TCPConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+
+	_ = client.TCPWriteFrame([]byte("hello"))
+	_ = client.TCPWriteFrame([]byte("world"))
+	_ = client.TCPFlush()
+	for i := 0; i < 2; i++ {
+		_, err = server.TCPReadFrame(16)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rejectedClient := new(BufferTcpConn)
+	_ = rejectedClient.TCPConnect("127.0.0.1", port, 1)
+	_, _, remoteClose, _ := rejectedClient.TCPRead(1)
+	if !remoteClose {
+		t.Fatal("expected the connection over the limit to be closed")
+	}
+
+	clientStats := client.Stats()
+	if clientStats.BytesOut != 18 || clientStats.FramesOut != 2 || clientStats.FlushCount != 1 || clientStats.WriteCount != 1 {
+		t.Fatal("unexpected client stats", clientStats)
+	}
+	serverStats := server.Stats()
+	if serverStats.BytesIn != 18 || serverStats.FramesIn != 2 || serverStats.ReadCount == 0 {
+		t.Fatal("unexpected server stats", serverStats)
+	}
+
+	_ = server.TCPDisConnect()
+	_ = server.TCPDisConnect()
+	time.Sleep(10 * time.Millisecond)
+	listenerStats := listener.Stats()
+	if listenerStats.Accepted != 1 || listenerStats.Rejected != 1 || listenerStats.Active != 0 ||
+		listenerStats.Conns.BytesIn != 18 {
+		t.Fatal("unexpected listener stats", listenerStats)
+	}
+
+	exporter := new(PrometheusExporter)
+	exporter.Initialise("buffer_tcp")
+	exporter.RegisterListener("main", listener)
+	exporter.RegisterConn(`cli"1`, client)
+	buf := new(bytes.Buffer)
+	_, _ = exporter.WriteTo(buf)
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE buffer_tcp_bytes_in_total counter\n",
+		"# TYPE buffer_tcp_listener_bytes_in_total counter\n",
+		"buffer_tcp_listener_bytes_in_total{listener=\"main\"} 18\n",
+		"buffer_tcp_bytes_out_total{conn=\"cli\\\"1\"} 18\n",
+		"buffer_tcp_rejected_total{listener=\"main\"} 1\n",
+		"# TYPE buffer_tcp_active_connections gauge\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Fatal("missing", line, "in", text)
+		}
+	}
+	if strings.Count(text, "# TYPE buffer_tcp_bytes_in_total") != 1 {
+		t.Fatal("duplicated metric family", text)
+	}
+	// the samples of a family share their label names
+	if strings.Contains(text, "buffer_tcp_bytes_in_total{listener=") ||
+		strings.Contains(text, "buffer_tcp_listener_bytes_in_total{conn=") {
+		t.Fatal("mixed label names in a metric family", text)
+	}
+	_ = client.TCPDisConnect()
+}
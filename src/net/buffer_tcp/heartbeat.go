@@ -0,0 +1,164 @@
+package buffer_tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// SetKeepAlive configures TCP keepalive probes, a zero period keeps the system default
+func (c *BufferTcpConn) SetKeepAlive(keepAlive bool, period time.Duration) error {
+	tcpConn, ok := c.conn.(*net.TCPConn)
+	if !ok {
+		return errors.New("keepalive not supported by the underlying connection")
+	}
+	err := tcpConn.SetKeepAlive(keepAlive)
+	if err != nil {
+		return err
+	}
+	if keepAlive && period > 0 {
+		return tcpConn.SetKeepAlivePeriod(period)
+	}
+	return nil
+}
+
+// SetKeepAlive enables TCP keepalive on the connections accepted afterwards, 0 leaves them untouched
+func (c *TcpListener) SetKeepAlive(period time.Duration) {
+	c.keepAlivePeriod = period
+}
+
+func (c *BufferTcpConn) touchRecv() {
+	atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+}
+
+func (c *BufferTcpConn) lastRecvTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastRecv))
+}
+
+func (c *BufferTcpConn) isClosed() bool {
+	return atomic.LoadUint32(&c.closed) == 1
+}
+
+// Heartbeat sends application pings on a connection quiet for an interval and closes it once
+// missThreshold pings in a row got no answer within an interval.
+// Any inbound byte counts as a pong, so the ping only has to make the peer answer.
+// Heartbeat does not read the connection and has no ping responder: the reader on each side must
+// answer the pings of its peer itself, e.g. with a pong frame, or only other traffic keeps the link alive.
+type Heartbeat struct {
+	mutex         *sync.Mutex
+	conn          *BufferTcpConn
+	interval      time.Duration
+	missThreshold int
+	sendPing      func(*BufferTcpConn) error
+	onDead        func(*BufferTcpConn, error)
+	quit          chan struct{}
+	finished      chan struct{}
+	stopped       bool
+	reason        error
+}
+
+// StartHeartbeat starts a heartbeat goroutine. sendPing must serialize with the other writers
+// of the connection. onDead, which may be nil, is called after the connection was closed.
+func (c *BufferTcpConn) StartHeartbeat(interval time.Duration, missThreshold int,
+	sendPing func(*BufferTcpConn) error, onDead func(*BufferTcpConn, error)) *Heartbeat {
+	h := new(Heartbeat)
+	if missThreshold < 1 {
+		missThreshold = 1
+	}
+	h.mutex = new(sync.Mutex)
+	h.conn = c
+	h.interval = interval
+	h.missThreshold = missThreshold
+	h.sendPing = sendPing
+	h.onDead = onDead
+	h.quit = make(chan struct{})
+	h.finished = make(chan struct{})
+	h.stopped = false
+	h.reason = nil
+	go h.run()
+	return h
+}
+
+func (h *Heartbeat) run() {
+	defer close(h.finished)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	misses := 0
+	pinging := false
+	lastPing := time.Time{}
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C:
+		}
+		if h.conn.isClosed() {
+			return
+		}
+
+		lastRecv := h.conn.lastRecvTime()
+		if pinging {
+			if lastRecv.After(lastPing) {
+				pinging = false
+				misses = 0
+			} else {
+				misses++
+				if misses >= h.missThreshold {
+					h.die(fmt.Errorf("%w: no data received for %v", ErrHeartbeatTimeout, time.Since(lastRecv)))
+					return
+				}
+			}
+		}
+		if time.Since(lastRecv) < h.interval {
+			continue
+		}
+		lastPing = time.Now()
+		pinging = true
+		err := h.sendPing(h.conn)
+		if err != nil {
+			h.die(fmt.Errorf("heartbeat ping failed: %w", err))
+			return
+		}
+	}
+}
+
+func (h *Heartbeat) die(reason error) {
+	h.mutex.Lock()
+	if h.stopped {
+		h.mutex.Unlock()
+		return
+	}
+	h.stopped = true
+	h.reason = reason
+	h.mutex.Unlock()
+
+	_ = h.conn.closeWithReason(reason)
+	if h.onDead != nil {
+		h.onDead(h.conn, reason)
+	}
+}
+
+// Stop ends the heartbeat and waits until no ping is in flight
+func (h *Heartbeat) Stop() {
+	h.mutex.Lock()
+	if h.stopped {
+		h.mutex.Unlock()
+		return
+	}
+	h.stopped = true
+	close(h.quit)
+	h.mutex.Unlock()
+	<-h.finished
+}
+
+// Reason returns why the heartbeat closed the connection, nil while it is alive
+func (h *Heartbeat) Reason() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.reason
+}
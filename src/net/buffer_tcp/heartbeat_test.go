@@ -0,0 +1,163 @@
+package buffer_tcp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tcpPair(t *testing.T) (*BufferTcpConn, *BufferTcpConn, *TcpListener) {
+	listener := new(TcpListener)
+	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.SetKeepAlive(time.Minute)
+	client := new(BufferTcpConn)
+	err = client.TCPConnect("127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.TCPAccept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server, listener
+}
+
+func TestHeartbeatDeadPeer(t *testing.T) {
+	client, server, listener := tcpPair(t)
+	defer listener.TCPListenClose()
+	defer server.TCPDisConnect()
+
+	err := client.SetKeepAlive(true, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := make(chan error, 1)
+	pings := 0
+	heartbeat := client.StartHeartbeat(20*time.Millisecond, 2, func(c *BufferTcpConn) error {
+		pings++
+		_ = c.TCPWrite([]byte{0})
+		return c.TCPFlush()
+	}, func(c *BufferTcpConn, reason error) {
+		dead <- reason
+	})
+
+	select {
+	case reason := <-dead:
+		if !errors.Is(reason, ErrHeartbeatTimeout) || !errors.Is(heartbeat.Reason(), ErrHeartbeatTimeout) {
+			t.Fatal("unexpected reason", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dead peer not detected")
+	}
+	if pings != 2 {
+		t.Fatal("unexpected ping count", pings)
+	}
+	_, _, _, err = client.TCPRead(1)
+	if err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestHeartbeatAlivePeer(t *testing.T) {
+	client, server, listener := tcpPair(t)
+	defer listener.TCPListenClose()
+
+	serverQuit := make(chan bool)
+	go func() {
+		for {
+			_, _, remoteClose, err := server.TCPRead(1)
+			if err != nil || remoteClose {
+				_ = server.TCPDisConnect()
+				serverQuit <- true
+				return
+			}
+			_ = server.TCPWrite([]byte{1})
+			_ = server.TCPFlush()
+		}
+	}()
+	go func() {
+		for {
+			_, _, remoteClose, err := client.TCPRead(1)
+			if err != nil || remoteClose {
+				return
+			}
+		}
+	}()
+
+	heartbeat := client.StartHeartbeat(10*time.Millisecond, 1, func(c *BufferTcpConn) error {
+		_ = c.TCPWrite([]byte{0})
+		return c.TCPFlush()
+	}, nil)
+	time.Sleep(100 * time.Millisecond)
+	heartbeat.Stop()
+	if heartbeat.Reason() != nil {
+		t.Fatal("alive peer reported dead", heartbeat.Reason())
+	}
+	_ = client.TCPDisConnect()
+	<-serverQuit
+
+	pipeConn, _ := net.Pipe()
+	conn := new(BufferTcpConn)
+	conn.initBuffers(pipeConn)
+	if conn.SetKeepAlive(true, 0) == nil {
+		t.Fatal("expected keepalive to be unsupported on pipes")
+	}
+}
+
+func TestHeartbeatBothPeers(t *testing.T) {
+	client, server, listener := tcpPair(t)
+	defer listener.TCPListenClose()
+
+	const ping, pong = byte(0), byte(1)
+	// the pings and the pongs of a side share its writer
+	writeMutexes := map[*BufferTcpConn]*sync.Mutex{client: new(sync.Mutex), server: new(sync.Mutex)}
+	write := func(conn *BufferTcpConn, b byte) error {
+		writeMutexes[conn].Lock()
+		defer writeMutexes[conn].Unlock()
+		_ = conn.TCPWrite([]byte{b})
+		return conn.TCPFlush()
+	}
+	// the readers answer the pings of their peer, a heartbeat does not
+	pongs := make(chan byte, 100)
+	answer := func(conn *BufferTcpConn) {
+		for {
+			data, _, remoteClose, err := conn.TCPRead(1)
+			if err != nil || remoteClose {
+				return
+			}
+			if data[0] == ping {
+				_ = write(conn, pong)
+			} else {
+				pongs <- data[0]
+			}
+		}
+	}
+	go answer(client)
+	go answer(server)
+
+	sendPing := func(c *BufferTcpConn) error {
+		return write(c, ping)
+	}
+	clientHeartbeat := client.StartHeartbeat(10*time.Millisecond, 1, sendPing, nil)
+	serverHeartbeat := server.StartHeartbeat(10*time.Millisecond, 1, sendPing, nil)
+	time.Sleep(100 * time.Millisecond)
+	clientHeartbeat.Stop()
+	serverHeartbeat.Stop()
+	if clientHeartbeat.Reason() != nil || serverHeartbeat.Reason() != nil {
+		t.Fatal("peers answering pings reported dead", clientHeartbeat.Reason(), serverHeartbeat.Reason())
+	}
+	if len(pongs) == 0 {
+		t.Fatal("expected pings to be answered")
+	}
+	for conn, writeMutex := range writeMutexes {
+		writeMutex.Lock()
+		_ = conn.TCPDisConnect()
+		writeMutex.Unlock()
+	}
+}
@@ -0,0 +1,385 @@
+package buffer_tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var errMemClosed = errors.New("use of closed memory connection")
+
+type memAddr string
+
+func (a memAddr) Network() string {
+	return "mem"
+}
+
+func (a memAddr) String() string {
+	return string(a)
+}
+
+type memTimeoutError struct{}
+
+func (memTimeoutError) Error() string {
+	return "i/o timeout"
+}
+
+func (memTimeoutError) Timeout() bool {
+	return true
+}
+
+func (memTimeoutError) Temporary() bool {
+	return true
+}
+
+// memStream is one direction of a memory connection, writes never block like a roomy socket buffer
+type memStream struct {
+	mutex  *sync.Mutex
+	notify chan struct{}
+	data   []byte
+	eof    bool
+	reset  bool
+}
+
+func newMemStream() *memStream {
+	return &memStream{mutex: new(sync.Mutex), notify: make(chan struct{})}
+}
+
+// wakeLocked releases every goroutine waiting on the stream
+func (s *memStream) wakeLocked() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+type memDeadline struct {
+	mutex    *sync.Mutex
+	deadline time.Time
+	changed  chan struct{}
+}
+
+func newMemDeadline() *memDeadline {
+	return &memDeadline{mutex: new(sync.Mutex), changed: make(chan struct{})}
+}
+
+func (d *memDeadline) set(t time.Time) {
+	d.mutex.Lock()
+	d.deadline = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+	d.mutex.Unlock()
+}
+
+// wait returns a channel closed when the deadline passes and a channel closed when it is changed
+func (d *memDeadline) wait() (<-chan time.Time, <-chan struct{}, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.deadline.IsZero() {
+		return nil, d.changed, false
+	}
+	left := time.Until(d.deadline)
+	if left <= 0 {
+		return nil, d.changed, true
+	}
+	return time.After(left), d.changed, false
+}
+
+type memConn struct {
+	readStream    *memStream
+	writeStream   *memStream
+	readDeadline  *memDeadline
+	writeDeadline *memDeadline
+	localAddr     memAddr
+	remoteAddr    memAddr
+	closed        chan struct{}
+	closeOnce     *sync.Once
+}
+
+func memPipe(localAddr memAddr, remoteAddr memAddr) (*memConn, *memConn) {
+	a := newMemStream()
+	b := newMemStream()
+	local := &memConn{readStream: a, writeStream: b, readDeadline: newMemDeadline(), writeDeadline: newMemDeadline(),
+		localAddr: localAddr, remoteAddr: remoteAddr, closed: make(chan struct{}), closeOnce: new(sync.Once)}
+	remote := &memConn{readStream: b, writeStream: a, readDeadline: newMemDeadline(), writeDeadline: newMemDeadline(),
+		localAddr: remoteAddr, remoteAddr: localAddr, closed: make(chan struct{}), closeOnce: new(sync.Once)}
+	return local, remote
+}
+
+func (c *memConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *memConn) Read(p []byte) (int, error) {
+	for {
+		if c.isClosed() {
+			return 0, errMemClosed
+		}
+		s := c.readStream
+		s.mutex.Lock()
+		if len(s.data) > 0 {
+			n := copy(p, s.data)
+			s.data = s.data[n:]
+			s.mutex.Unlock()
+			return n, nil
+		}
+		if s.reset {
+			s.mutex.Unlock()
+			return 0, &net.OpError{Op: "read", Net: "mem", Addr: c.remoteAddr, Err: syscall.ECONNRESET}
+		}
+		if s.eof {
+			s.mutex.Unlock()
+			return 0, io.EOF
+		}
+		notify := s.notify
+		s.mutex.Unlock()
+
+		timeOut, changed, expired := c.readDeadline.wait()
+		if expired {
+			return 0, &net.OpError{Op: "read", Net: "mem", Addr: c.remoteAddr, Err: memTimeoutError{}}
+		}
+		select {
+		case <-notify:
+		case <-changed:
+		case <-timeOut:
+		case <-c.closed:
+		}
+	}
+}
+
+func (c *memConn) Write(p []byte) (int, error) {
+	if c.isClosed() {
+		return 0, errMemClosed
+	}
+	_, _, expired := c.writeDeadline.wait()
+	if expired {
+		return 0, &net.OpError{Op: "write", Net: "mem", Addr: c.remoteAddr, Err: memTimeoutError{}}
+	}
+	s := c.writeStream
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reset {
+		return 0, &net.OpError{Op: "write", Net: "mem", Addr: c.remoteAddr, Err: syscall.ECONNRESET}
+	}
+	if s.eof {
+		return 0, &net.OpError{Op: "write", Net: "mem", Addr: c.remoteAddr, Err: syscall.EPIPE}
+	}
+	s.data = append(s.data, p...)
+	s.wakeLocked()
+	return len(p), nil
+}
+
+// CloseWrite sends EOF to the peer while reads keep working
+func (c *memConn) CloseWrite() error {
+	s := c.writeStream
+	s.mutex.Lock()
+	s.eof = true
+	s.wakeLocked()
+	s.mutex.Unlock()
+	return nil
+}
+
+func (c *memConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.CloseWrite()
+	})
+	return nil
+}
+
+// Reset aborts both directions, the peer reads what was already sent and then fails with ECONNRESET
+func (c *memConn) Reset() {
+	for _, s := range []*memStream{c.readStream, c.writeStream} {
+		s.mutex.Lock()
+		s.reset = true
+		s.wakeLocked()
+		s.mutex.Unlock()
+	}
+	_ = c.Close()
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *memConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *memConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// MemListener is an in-process net.Listener, Dial returns the client end of a buffered memory connection.
+// Use it with TcpListener.TCPListenOn and BufferTcpConn.TCPAttach to test protocol code without sockets.
+type MemListener struct {
+	name      string
+	counter   uint64
+	pending   chan net.Conn
+	closed    chan struct{}
+	closeOnce *sync.Once
+	faults    *FaultConfig
+}
+
+func (l *MemListener) Initialise(name string) {
+	l.name = name
+	l.counter = 0
+	l.pending = make(chan net.Conn)
+	l.closed = make(chan struct{})
+	l.closeOnce = new(sync.Once)
+	l.faults = nil
+}
+
+// SetFaults wraps the server end of connections dialed afterwards in a FaultConn
+func (l *MemListener) SetFaults(config FaultConfig) {
+	l.faults = &config
+}
+
+func (l *MemListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.pending:
+		return conn, nil
+	case <-l.closed:
+		return nil, errMemClosed
+	}
+}
+
+func (l *MemListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *MemListener) Addr() net.Addr {
+	return memAddr(l.name)
+}
+
+func (l *MemListener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background())
+}
+
+func (l *MemListener) DialContext(ctx context.Context) (net.Conn, error) {
+	clientAddr := memAddr(l.name + "-client-" + strconv.FormatUint(atomic.AddUint64(&l.counter, 1), 10))
+	client, server := memPipe(clientAddr, memAddr(l.name))
+	var serverConn net.Conn = server
+	if l.faults != nil {
+		faultConn := new(FaultConn)
+		faultConn.Initialise(server, *l.faults)
+		serverConn = faultConn
+	}
+	select {
+	case l.pending <- serverConn:
+		return client, nil
+	case <-l.closed:
+		return nil, errors.New("connection refused: memory listener closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type FaultConfig struct {
+	// delay added to every Read and Write
+	Latency time.Duration
+	// upper bound of bytes returned by a single Read, 0 means unlimited
+	MaxReadSize int
+	// reset the connection once this many bytes went through in both directions, 0 disables
+	ResetAfterBytes int64
+}
+
+// FaultConn wraps a net.Conn and injects latency, short reads and connection resets
+type FaultConn struct {
+	net.Conn
+	config      FaultConfig
+	transferred int64
+}
+
+func (f *FaultConn) Initialise(conn net.Conn, config FaultConfig) {
+	f.Conn = conn
+	f.config = config
+	f.transferred = 0
+}
+
+func (f *FaultConn) reset(op string) error {
+	memConn, ok := f.Conn.(*memConn)
+	if ok {
+		memConn.Reset()
+	} else {
+		tcpConn, ok := f.Conn.(*net.TCPConn)
+		if ok {
+			_ = tcpConn.SetLinger(0)
+		}
+		_ = f.Conn.Close()
+	}
+	return &net.OpError{Op: op, Net: f.Conn.LocalAddr().Network(), Addr: f.Conn.RemoteAddr(), Err: syscall.ECONNRESET}
+}
+
+// budget returns how many bytes may pass before the injected reset, -1 without limit
+func (f *FaultConn) budget() int64 {
+	if f.config.ResetAfterBytes <= 0 {
+		return -1
+	}
+	left := f.config.ResetAfterBytes - atomic.LoadInt64(&f.transferred)
+	if left < 0 {
+		left = 0
+	}
+	return left
+}
+
+func (f *FaultConn) Read(p []byte) (int, error) {
+	if f.config.Latency > 0 {
+		time.Sleep(f.config.Latency)
+	}
+	if f.config.MaxReadSize > 0 && len(p) > f.config.MaxReadSize {
+		p = p[0:f.config.MaxReadSize]
+	}
+	budget := f.budget()
+	if budget == 0 {
+		return 0, f.reset("read")
+	}
+	if budget > 0 && int64(len(p)) > budget {
+		p = p[0:budget]
+	}
+	n, err := f.Conn.Read(p)
+	atomic.AddInt64(&f.transferred, int64(n))
+	return n, err
+}
+
+func (f *FaultConn) Write(p []byte) (int, error) {
+	if f.config.Latency > 0 {
+		time.Sleep(f.config.Latency)
+	}
+	budget := f.budget()
+	if budget == 0 {
+		return 0, f.reset("write")
+	}
+	if budget > 0 && int64(len(p)) > budget {
+		n, _ := f.Conn.Write(p[0:budget])
+		atomic.AddInt64(&f.transferred, int64(n))
+		return n, f.reset("write")
+	}
+	n, err := f.Conn.Write(p)
+	atomic.AddInt64(&f.transferred, int64(n))
+	return n, err
+}
@@ -0,0 +1,93 @@
+package buffer_tcp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func memConnPair(t *testing.T, faults *FaultConfig) (*BufferTcpConn, *BufferTcpConn, *MemListener) {
+	memListener := new(MemListener)
+	memListener.Initialise("mem-test")
+	if faults != nil {
+		memListener.SetFaults(*faults)
+	}
+	listener := new(TcpListener)
+	listener.TCPListenOn(memListener)
+
+	accepted := make(chan *BufferTcpConn)
+	go func() {
+		conn, err := listener.TCPAccept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	clientConn, err := memListener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := new(BufferTcpConn)
+	client.TCPAttach(clientConn)
+	return client, <-accepted, memListener
+}
+
+func TestMemTransport(t *testing.T) {
+	client, server, memListener := memConnPair(t, nil)
+
+	_ = client.TCPWriteFrame([]byte("ping"))
+	_ = client.TCPFlush()
+	frame, err := server.TCPReadFrame(16)
+	if err != nil || string(frame) != "ping" {
+		t.Fatal("unexpected frame", string(frame), err)
+	}
+	if server.RemoteAddr().Network() != "mem" || server.LocalAddr().String() != "mem-test" {
+		t.Fatal("unexpected addresses", server.LocalAddr(), server.RemoteAddr())
+	}
+
+	_ = server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, _, _, err = server.TCPRead(1)
+	netErr, ok := err.(net.Error)
+	if !ok || !netErr.Timeout() {
+		t.Fatal("expected timeout", err)
+	}
+	_ = server.SetReadDeadline(time.Time{})
+
+	_ = client.TCPDisConnect()
+	_, _, remoteClose, err := server.TCPRead(1)
+	if err != nil || !remoteClose {
+		t.Fatal("expected EOF", remoteClose, err)
+	}
+
+	_ = memListener.Close()
+	_, err = memListener.Dial()
+	if err == nil {
+		t.Fatal("expected dial to fail on a closed listener")
+	}
+}
+
+func TestMemTransportFaults(t *testing.T) {
+	client, server, _ := memConnPair(t, &FaultConfig{MaxReadSize: 3, ResetAfterBytes: 10, Latency: time.Millisecond})
+
+	_ = client.TCPWrite([]byte("0123456789abcdef"))
+	_ = client.TCPFlush()
+	start := time.Now()
+	data, _, _, err := server.TCPRead(10)
+	if err != nil || string(data) != "0123456789" {
+		t.Fatal("unexpected data", string(data), err)
+	}
+	if server.Stats().ReadCount != 4 || time.Since(start) < 4*time.Millisecond {
+		t.Fatal("expected short, delayed reads", server.Stats())
+	}
+	_, _, _, err = server.TCPRead(1)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("expected reset", err)
+	}
+	_, err = ioutil.ReadAll(client)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("expected the peer to see the reset", err)
+	}
+}
@@ -0,0 +1,176 @@
+package buffer_tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProxyCommandLocal = uint8(0)
+	ProxyCommandProxy = uint8(1)
+)
+
+// longest v1 header including CRLF, see the PROXY protocol specification
+const proxyV1MaxLength = 107
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+// ProxyHeader is the HAProxy PROXY protocol header received ahead of the stream.
+// Addresses are nil for LOCAL commands and UNKNOWN or non-inet families.
+type ProxyHeader struct {
+	Version    uint8
+	Command    uint8
+	SourceAddr net.Addr
+	DestAddr   net.Addr
+}
+
+// SetProxyProtocol makes TCPAccept require a PROXY protocol v1 or v2 header on every connection.
+// Connections which do not send a valid header within headerTimeOut seconds are closed and counted as rejected,
+// 0 waits DefaultHandshakeTimeOut. Every header is read on a goroutine of its own, so a slow client
+// does not delay the other accepts.
+func (c *TcpListener) SetProxyProtocol(enable bool, headerTimeOut float64) {
+	c.proxyProtocol = enable
+	c.proxyHeaderTimeOut = headerTimeOut
+}
+
+func (c *TcpListener) acceptProxyHeader(tcpConn *BufferTcpConn) error {
+	err := tcpConn.SetReadDeadline(handshakeDeadline(c.proxyHeaderTimeOut))
+	if err != nil {
+		return err
+	}
+	header, err := tcpConn.readProxyHeader()
+	if err != nil {
+		return err
+	}
+	tcpConn.proxyHeader = header
+	return tcpConn.SetReadDeadline(time.Time{})
+}
+
+// ProxyHeader returns the PROXY protocol header of the connection, nil if the listener did not require one
+func (c *BufferTcpConn) ProxyHeader() *ProxyHeader {
+	return c.proxyHeader
+}
+
+// ClientAddr returns the client address announced by the proxy, or RemoteAddr without one
+func (c *BufferTcpConn) ClientAddr() net.Addr {
+	if c.proxyHeader != nil && c.proxyHeader.SourceAddr != nil {
+		return c.proxyHeader.SourceAddr
+	}
+	return c.RemoteAddr()
+}
+
+func (c *BufferTcpConn) readProxyHeader() (*ProxyHeader, error) {
+	prefix, err := c.Peek(uint32(len(proxyV2Signature)))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyV2Signature) {
+		return c.readProxyHeaderV2()
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return c.readProxyHeaderV1()
+	}
+	return nil, ErrInvalidProxyHeader
+}
+
+func (c *BufferTcpConn) readProxyHeaderV1() (*ProxyHeader, error) {
+	lineLength := 0
+	for n := uint32(len(proxyV2Signature)); n <= proxyV1MaxLength; n++ {
+		peeked, err := c.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(peeked, []byte("\r\n")) {
+			lineLength = int(n)
+			break
+		}
+	}
+	if lineLength == 0 {
+		return nil, ErrInvalidProxyHeader
+	}
+	line, _, _, err := c.TCPRead(uint32(lineLength))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(string(line[:lineLength-2]), " ")
+	header := &ProxyHeader{Version: 1, Command: ProxyCommandProxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	if (fields[1] == "TCP4") != (srcIP.To4() != nil) || (fields[1] == "TCP4") != (dstIP.To4() != nil) {
+		return nil, ErrInvalidProxyHeader
+	}
+	header.SourceAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	header.DestAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return header, nil
+}
+
+func (c *BufferTcpConn) readProxyHeaderV2() (*ProxyHeader, error) {
+	fixed, err := c.Peek(16)
+	if err != nil {
+		return nil, err
+	}
+	verCmd := fixed[12]
+	family := fixed[13]
+	length := binary.BigEndian.Uint16(fixed[14:16])
+	if verCmd>>4 != 2 || verCmd&0x0f > ProxyCommandProxy {
+		return nil, ErrInvalidProxyHeader
+	}
+	_, _ = c.Discard(16)
+	body, n, _, err := c.TCPRead(uint32(length))
+	if err != nil {
+		return nil, err
+	}
+	if n != uint32(length) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	header := &ProxyHeader{Version: 2, Command: verCmd & 0x0f}
+	if header.Command == ProxyCommandLocal {
+		return header, nil
+	}
+	ipLength := 0
+	switch family >> 4 {
+	case 1:
+		ipLength = net.IPv4len
+	case 2:
+		ipLength = net.IPv6len
+	default:
+		// AF_UNSPEC and AF_UNIX carry no inet address
+		return header, nil
+	}
+	if len(body) < ipLength*2+4 {
+		return nil, ErrInvalidProxyHeader
+	}
+	srcIP := net.IP(body[0:ipLength])
+	dstIP := net.IP(body[ipLength : ipLength*2])
+	srcPort := int(binary.BigEndian.Uint16(body[ipLength*2:]))
+	dstPort := int(binary.BigEndian.Uint16(body[ipLength*2+2:]))
+	switch family & 0x0f {
+	case 1:
+		header.SourceAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		header.DestAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	case 2:
+		header.SourceAddr = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		header.DestAddr = &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return header, nil
+}
@@ -0,0 +1,179 @@
+package buffer_tcp
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func parseProxyHeader(t *testing.T, raw []byte) (*ProxyHeader, *BufferTcpConn, error) {
+	server, client := net.Pipe()
+	go func() {
+		_, _ = client.Write(raw)
+		_, _ = client.Write([]byte("payload"))
+		_ = client.Close()
+	}()
+	conn := new(BufferTcpConn)
+	conn.initBuffers(server)
+	header, err := conn.readProxyHeader()
+	return header, conn, err
+}
+
+func proxyV2Header(command uint8, family uint8, addrs []byte) []byte {
+	raw := append([]byte{}, proxyV2Signature...)
+	raw = append(raw, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(raw[14:], uint16(len(addrs)))
+	return append(raw, addrs...)
+}
+
+func TestProxyHeaderV1(t *testing.T) {
+	header, conn, err := parseProxyHeader(t, []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 1 || header.SourceAddr.String() != "192.168.0.1:56324" || header.DestAddr.String() != "192.168.0.11:443" {
+		t.Fatal("unexpected header", header)
+	}
+	payload, _, _, _ := conn.TCPRead(7)
+	if string(payload) != "payload" {
+		t.Fatal("header not consumed", string(payload))
+	}
+
+	header, _, err = parseProxyHeader(t, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 2000\r\n"))
+	if err != nil || header.SourceAddr.String() != "[2001:db8::1]:1000" {
+		t.Fatal("unexpected header", header, err)
+	}
+	header, _, err = parseProxyHeader(t, []byte("PROXY UNKNOWN\r\n"))
+	if err != nil || header.SourceAddr != nil {
+		t.Fatal("unexpected header", header, err)
+	}
+
+	for _, raw := range []string{
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",
+		"PROXY TCP4 2001:db8::1 192.168.0.11 1 2\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 70000 443\r\n",
+		"GET / HTTP/1.1\r\nHost: example\r\n\r\n",
+	} {
+		_, _, err = parseProxyHeader(t, []byte(raw))
+		if err == nil {
+			t.Fatal("expected malformed header error", raw)
+		}
+	}
+}
+
+func TestProxyHeaderV2(t *testing.T) {
+	addrs := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb, 0x04, 0x00, 0x01, 0x00}
+	header, conn, err := parseProxyHeader(t, proxyV2Header(ProxyCommandProxy, 0x11, addrs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.SourceAddr.String() != "10.0.0.1:8080" || header.DestAddr.String() != "10.0.0.2:443" {
+		t.Fatal("unexpected header", header)
+	}
+	payload, _, _, _ := conn.TCPRead(7)
+	if string(payload) != "payload" {
+		t.Fatal("header not consumed", string(payload))
+	}
+
+	header, _, err = parseProxyHeader(t, proxyV2Header(ProxyCommandLocal, 0x00, nil))
+	if err != nil || header.Command != ProxyCommandLocal || header.SourceAddr != nil {
+		t.Fatal("unexpected header", header, err)
+	}
+	_, _, err = parseProxyHeader(t, proxyV2Header(ProxyCommandProxy, 0x21, addrs))
+	if err == nil {
+		t.Fatal("expected short address error")
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	listener := new(TcpListener)
+	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.TCPListenClose()
+	listener.SetProxyProtocol(true, 0.05)
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	accepted := make(chan *BufferTcpConn, 1)
+	go func() {
+		conn, err := listener.TCPAccept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	silent := new(BufferTcpConn)
+	_ = silent.TCPConnect("127.0.0.1", port, 1)
+	defer silent.TCPDisConnect()
+	malformed := new(BufferTcpConn)
+	_ = malformed.TCPConnect("127.0.0.1", port, 1)
+	defer malformed.TCPDisConnect()
+	_ = malformed.TCPWrite([]byte("PROXY TCP4 nonsense\r\n"))
+	_ = malformed.TCPFlush()
+	time.Sleep(100 * time.Millisecond)
+
+	proxied := new(BufferTcpConn)
+	_ = proxied.TCPConnect("127.0.0.1", port, 1)
+	defer proxied.TCPDisConnect()
+	_ = proxied.TCPWrite([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 80\r\n"))
+	_ = proxied.TCPFlush()
+
+	select {
+	case conn := <-accepted:
+		if conn.ClientAddr().String() != "203.0.113.7:40000" || conn.ProxyHeader() == nil {
+			t.Fatal("unexpected client address", conn.ClientAddr())
+		}
+		_ = conn.TCPDisConnect()
+	case <-time.After(time.Second):
+		t.Fatal("proxied connection not accepted")
+	}
+	if listener.Stats().Rejected != 2 || listener.Stats().Accepted != 1 {
+		t.Fatal("unexpected listener stats", listener.Stats())
+	}
+}
+
+func TestProxyListenerSlowClient(t *testing.T) {
+	listener := new(TcpListener)
+	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0 waits DefaultHandshakeTimeOut for the header
+	listener.SetProxyProtocol(true, 0)
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	silent := new(BufferTcpConn)
+	_ = silent.TCPConnect("127.0.0.1", port, 1)
+	defer silent.TCPDisConnect()
+	accepted := make(chan *BufferTcpConn, 1)
+	go func() {
+		conn, err := listener.TCPAccept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	proxied := new(BufferTcpConn)
+	_ = proxied.TCPConnect("127.0.0.1", port, 1)
+	defer proxied.TCPDisConnect()
+	_ = proxied.TCPWrite([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 80\r\n"))
+	_ = proxied.TCPFlush()
+	select {
+	case conn := <-accepted:
+		if conn.ClientAddr().String() != "203.0.113.7:40000" {
+			t.Fatal("unexpected client address", conn.ClientAddr())
+		}
+		_ = conn.TCPDisConnect()
+	case <-time.After(time.Second):
+		t.Fatal("the silent client held back the accept")
+	}
+
+	listener.TCPListenClose()
+	_, err = listener.TCPAccept()
+	if err == nil {
+		t.Fatal("expected an error after closing the listener")
+	}
+}
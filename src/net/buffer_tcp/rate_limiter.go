@@ -0,0 +1,98 @@
+package buffer_tcp
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// RateLimiter is a token bucket counted in bytes, it can be shared by several connections
+type RateLimiter struct {
+	mutex  *sync.Mutex
+	clock  Clock
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// Initialise sets the sustained rate in bytes per second and the bucket size in bytes.
+// A rate of 0 or less is unlimited, a nil clock uses the system clock.
+func (r *RateLimiter) Initialise(bytesPerSecond float64, burst uint32, clock Clock) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	if burst == 0 {
+		burst = 1
+	}
+	r.mutex = new(sync.Mutex)
+	r.clock = clock
+	r.rate = bytesPerSecond
+	r.burst = int(burst)
+	r.tokens = float64(burst)
+	r.last = clock.Now()
+}
+
+func (r *RateLimiter) Burst() int {
+	return r.burst
+}
+
+// unlimited reports a rate of 0 or less, whose burst must not split reads and writes
+func (r *RateLimiter) unlimited() bool {
+	return r.rate <= 0
+}
+
+// reserve takes n tokens, going into debt if necessary, and returns how long the caller has to wait
+func (r *RateLimiter) reserve(n int) time.Duration {
+	if r.unlimited() {
+		return 0
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.clock.Now()
+	elapsed := now.Sub(r.last)
+	if elapsed > 0 {
+		r.tokens = r.tokens + elapsed.Seconds()*r.rate
+		if r.tokens > float64(r.burst) {
+			r.tokens = float64(r.burst)
+		}
+		r.last = now
+	}
+	r.tokens = r.tokens - float64(n)
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// WaitN blocks until n bytes may pass, requests larger than the burst are split
+func (r *RateLimiter) WaitN(n int) {
+	if r.unlimited() {
+		return
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > r.burst {
+			chunk = r.burst
+		}
+		wait := r.reserve(chunk)
+		if wait > 0 {
+			r.clock.Sleep(wait)
+		}
+		n = n - chunk
+	}
+}
@@ -0,0 +1,135 @@
+package buffer_tcp
+
+import (
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mutex *sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{mutex: new(sync.Mutex), now: time.Unix(0, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Sleep(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	f.slept = f.slept + d
+}
+
+func (f *fakeClock) Slept() time.Duration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.slept
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter := new(RateLimiter)
+	limiter.Initialise(100, 10, clock)
+
+	limiter.WaitN(10)
+	if clock.Slept() != 0 {
+		t.Fatal("burst should pass without waiting", clock.Slept())
+	}
+	limiter.WaitN(5)
+	if clock.Slept() != 50*time.Millisecond {
+		t.Fatal("unexpected wait", clock.Slept())
+	}
+	clock.Sleep(time.Second)
+	limiter.WaitN(25)
+	if clock.Slept() != 50*time.Millisecond+time.Second+150*time.Millisecond {
+		t.Fatal("unexpected wait", clock.Slept())
+	}
+}
+
+func TestConnRateLimit(t *testing.T) {
+	server, client := net.Pipe()
+	writeClock := newFakeClock()
+	writeLimiter := new(RateLimiter)
+	writeLimiter.Initialise(1000, 100, writeClock)
+	sender := new(BufferTcpConn)
+	sender.initBuffers(client)
+	sender.SetRateLimiters(nil, writeLimiter)
+
+	readClock := newFakeClock()
+	readLimiter := new(RateLimiter)
+	readLimiter.Initialise(1000, 200, readClock)
+	receiver := new(BufferTcpConn)
+	receiver.initBuffers(server)
+	receiver.SetRateLimiters(readLimiter, nil)
+
+	go func() {
+		_ = sender.TCPWrite(make([]byte, 500))
+		_ = sender.TCPDisConnect()
+	}()
+	data, err := ioutil.ReadAll(receiver)
+	if err != nil || len(data) != 500 {
+		t.Fatal("unexpected read", len(data), err)
+	}
+	if writeClock.Slept() != 400*time.Millisecond {
+		t.Fatal("unexpected write throttling", writeClock.Slept())
+	}
+	if readClock.Slept() != 300*time.Millisecond {
+		t.Fatal("unexpected read throttling", readClock.Slept())
+	}
+	if sender.Stats().WriteCount != 5 {
+		t.Fatal("expected writes split by burst", sender.Stats())
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		clock := newFakeClock()
+		limiter := new(RateLimiter)
+		limiter.Initialise(rate, 1024, clock)
+		limiter.WaitN(10 * 1024)
+		if clock.Slept() != 0 {
+			t.Fatal("expected no wait without a rate", rate, clock.Slept())
+		}
+	}
+}
+
+func TestConnRateUnlimited(t *testing.T) {
+	server, client := net.Pipe()
+	writeLimiter := new(RateLimiter)
+	writeLimiter.Initialise(0, 0, nil)
+	sender := new(BufferTcpConn)
+	sender.initBuffers(client)
+	sender.SetRateLimiters(nil, writeLimiter)
+	readLimiter := new(RateLimiter)
+	readLimiter.Initialise(0, 0, nil)
+	receiver := new(BufferTcpConn)
+	receiver.initBuffers(server)
+	receiver.SetRateLimiters(readLimiter, nil)
+
+	go func() {
+		_ = sender.TCPWrite(make([]byte, 500))
+		_ = sender.TCPDisConnect()
+	}()
+	data, err := ioutil.ReadAll(receiver)
+	if err != nil || len(data) != 500 {
+		t.Fatal("unexpected read", len(data), err)
+	}
+	// the burst of an unlimited limiter does not split reads and writes
+	if sender.Stats().WriteCount != 1 {
+		t.Fatal("expected a single write", sender.Stats())
+	}
+	if receiver.Stats().ReadCount > 2 {
+		t.Fatal("expected reads of the whole data", receiver.Stats())
+	}
+}
@@ -0,0 +1,150 @@
+package buffer_tcp
+
+import (
+	"errors"
+)
+
+type ringBuffer struct {
+	buf   []byte
+	head  int
+	size  int
+	limit int
+}
+
+func newRingBuffer(initSize int, limit int) *ringBuffer {
+	if initSize > limit {
+		initSize = limit
+	}
+	r := new(ringBuffer)
+	r.buf = make([]byte, initSize)
+	r.head = 0
+	r.size = 0
+	r.limit = limit
+	return r
+}
+
+func (r *ringBuffer) Len() int {
+	return r.size
+}
+
+func (r *ringBuffer) Cap() int {
+	return len(r.buf)
+}
+
+func (r *ringBuffer) Free() int {
+	return len(r.buf) - r.size
+}
+
+func (r *ringBuffer) tail() int {
+	return (r.head + r.size) % len(r.buf)
+}
+
+// relocate copies the buffered bytes to the start of a buffer of newCap bytes
+func (r *ringBuffer) relocate(newCap int) {
+	newBuf := make([]byte, newCap)
+	first := len(r.buf) - r.head
+	if first >= r.size {
+		copy(newBuf, r.buf[r.head:r.head+r.size])
+	} else {
+		copy(newBuf, r.buf[r.head:])
+		copy(newBuf[first:], r.buf[:r.size-first])
+	}
+	r.buf = newBuf
+	r.head = 0
+}
+
+// Grow makes sure at least n bytes can be written without exceeding the limit
+func (r *ringBuffer) Grow(n int) error {
+	if r.Free() >= n {
+		return nil
+	}
+	if r.size+n > r.limit {
+		return errors.New("ring buffer: exceeds max buffer size")
+	}
+	newCap := len(r.buf) * 2
+	if newCap == 0 {
+		newCap = n
+	}
+	for newCap < r.size+n {
+		newCap = newCap * 2
+	}
+	if newCap > r.limit {
+		newCap = r.limit
+	}
+	r.relocate(newCap)
+	return nil
+}
+
+// WritableSlice returns the contiguous free region following the buffered bytes
+func (r *ringBuffer) WritableSlice() []byte {
+	if r.size == 0 {
+		r.head = 0
+	}
+	if r.size == len(r.buf) {
+		return nil
+	}
+	tail := r.tail()
+	if tail >= r.head {
+		return r.buf[tail:]
+	}
+	return r.buf[tail:r.head]
+}
+
+func (r *ringBuffer) Commit(n int) {
+	r.size = r.size + n
+}
+
+func (r *ringBuffer) Write(p []byte) error {
+	err := r.Grow(len(p))
+	if err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := copy(r.WritableSlice(), p)
+		r.Commit(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// Peek returns a view of the first n buffered bytes, valid until the next modification
+func (r *ringBuffer) Peek(n int) []byte {
+	if n > r.size {
+		n = r.size
+	}
+	if r.head+n > len(r.buf) {
+		r.relocate(len(r.buf))
+	}
+	return r.buf[r.head : r.head+n]
+}
+
+func (r *ringBuffer) Discard(n int) int {
+	if n > r.size {
+		n = r.size
+	}
+	r.head = (r.head + n) % len(r.buf)
+	r.size = r.size - n
+	if r.size == 0 {
+		r.head = 0
+	}
+	return n
+}
+
+func (r *ringBuffer) Read(p []byte) int {
+	n := 0
+	for n < len(p) && r.size > 0 {
+		end := r.head + r.size
+		if end > len(r.buf) {
+			end = len(r.buf)
+		}
+		m := copy(p[n:], r.buf[r.head:end])
+		r.Discard(m)
+		n = n + m
+	}
+	return n
+}
+
+func (r *ringBuffer) Reset() {
+	r.head = 0
+	r.size = 0
+}
@@ -0,0 +1,42 @@
+package buffer_tcp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRingBufferWrap(t *testing.T) {
+	r := newRingBuffer(8, 8)
+	_ = r.Write([]byte("abcdef"))
+	r.Discard(4)
+	err := r.Write([]byte("ghijk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cap() != 8 || r.Len() != 7 {
+		t.Fatal("unexpected cap/len", r.Cap(), r.Len())
+	}
+	if !bytes.Equal(r.Peek(7), []byte("efghijk")) {
+		t.Fatal("unexpected peek", string(r.Peek(7)))
+	}
+	out := make([]byte, 3)
+	r.Read(out)
+	if string(out) != "efg" || r.Len() != 4 {
+		t.Fatal("unexpected read", string(out))
+	}
+}
+
+func TestRingBufferLimit(t *testing.T) {
+	r := newRingBuffer(4, 16)
+	err := r.Write([]byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cap() != 16 {
+		t.Fatal("unexpected cap", r.Cap())
+	}
+	err = r.Write([]byte("0123456789"))
+	if err == nil {
+		t.Fatal("expected overflow error")
+	}
+}
@@ -2,8 +2,11 @@ package buffer_tcp
 
 import (
 	"fmt"
+	"github.com/mutalisk999/go-lib/src/io/serialization"
 	. "github.com/mutalisk999/go-lib/src/sched/goroutine_mgr"
+	"io"
 	"net"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -12,22 +15,23 @@ func TestTcpServer(t *testing.T) {
 	manager := new(GoroutineManager)
 	manager.Initialise("mgr1")
 
+	memListener := new(MemListener)
+	memListener.Initialise("mem-server")
 	listener := new(TcpListener)
-	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("0.0.0.0"), Port: 8888})
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+	listener.TCPListenOn(memListener)
+	defer listener.TCPListenClose()
 
-	manager.GoroutineCreatePn("connector", connectorCallback, nil)
+	manager.GoroutineCreatePn("connector", connectorCallback, memListener)
 
-	for {
-		conn, err := listener.TCPAccept()
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		manager.GoroutineCreateP1("receiver", receiverCallback, conn)
+	conn, err := listener.TCPAccept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan int)
+	manager.GoroutineCreateP2("receiver", receiverCallback, conn, received)
+	total := <-received
+	if total != 100*17 {
+		t.Fatal("unexpected received bytes", total)
 	}
 }
 
@@ -35,13 +39,14 @@ func connectorCallback(g Goroutine, args ...interface{}) {
 	defer g.OnQuit()
 
 	fmt.Println("connectorCallback")
-	conn := new(BufferTcpConn)
-	err := conn.TCPConnect("127.0.0.1", 8888, 1)
-
+	memListener := args[0].(*MemListener)
+	client, err := memListener.Dial()
 	if err != nil {
 		fmt.Println("connect error")
 		return
 	}
+	conn := new(BufferTcpConn)
+	conn.TCPAttach(client)
 
 	for i := 0; i < 100; i++ {
 		_ = conn.TCPWrite([]byte("1234567890abcdefg"))
@@ -51,17 +56,142 @@ func connectorCallback(g Goroutine, args ...interface{}) {
 	_ = conn.TCPDisConnect()
 }
 
-func receiverCallback(g Goroutine, conn interface{}) {
+func receiverCallback(g Goroutine, conn interface{}, received interface{}) {
 	defer g.OnQuit()
 
 	fmt.Println("receiverCallback")
+	total := 0
 	for {
 		c, _ := conn.(*BufferTcpConn)
 		buffer, _, flag, err := c.TCPRead(1000)
 		fmt.Println("receiver", buffer, flag, err)
+		total = total + len(buffer)
 		if err == nil && flag != true {
 		} else {
 			break
 		}
 	}
+	received.(chan int) <- total
+}
+
+func TestPeekDiscard(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte{0x05, 'h', 'e', 'l', 'l', 'o', 'w', 'o'})
+		_, _ = client.Write([]byte("rld"))
+		_ = client.Close()
+	}()
+
+	conn := new(BufferTcpConn)
+	conn.SetConfig(BufferTcpConfig{ReadChunkSize: 4, MaxBufferSize: 16})
+	conn.initBuffers(server)
+
+	header, err := conn.Peek(1)
+	if err != nil || header[0] != 0x05 {
+		t.Fatal("unexpected header", header, err)
+	}
+	_, _ = conn.Discard(1)
+	body, n, _, err := conn.TCPRead(uint32(header[0]))
+	if err != nil || n != 5 || string(body) != "hello" {
+		t.Fatal("unexpected body", string(body), err)
+	}
+	discarded, err := conn.Discard(2)
+	if err != nil || discarded != 2 {
+		t.Fatal("unexpected discard", discarded, err)
+	}
+	_, err = conn.Peek(10)
+	if err != io.EOF {
+		t.Fatal("expected EOF", err)
+	}
+	rest, _, remoteClose, _ := conn.TCPRead(10)
+	if string(rest) != "rld" || !remoteClose {
+		t.Fatal("unexpected rest", string(rest), remoteClose)
+	}
+	_, _, _, err = conn.TCPRead(17)
+	if err == nil {
+		t.Fatal("expected max buffer size error")
+	}
+}
+
+func TestSerializationStream(t *testing.T) {
+	server, client := net.Pipe()
+	sender := new(BufferTcpConn)
+	sender.SetConfig(BufferTcpConfig{SendFlushSize: 16})
+	sender.initBuffers(client)
+	receiver := new(BufferTcpConn)
+	receiver.SetConfig(BufferTcpConfig{ReadChunkSize: 3})
+	receiver.initBuffers(server)
+
+	go func() {
+		_ = serialization.Pack(sender, uint32(0x12345678))
+		_ = serialization.Pack(sender, []string{"abc", "defghijklmnopqrstuvwxyz"})
+		_ = serialization.Pack(sender, map[string]int{"a": 1})
+		_ = sender.Close()
+	}()
+
+	u32, err := serialization.UnPack(receiver, reflect.TypeOf(uint32(0)))
+	if err != nil || u32.(uint32) != 0x12345678 {
+		t.Fatal("unexpected uint32", u32, err)
+	}
+	s, err := serialization.UnPack(receiver, reflect.TypeOf([]string{}))
+	if err != nil || !reflect.DeepEqual(s, []string{"abc", "defghijklmnopqrstuvwxyz"}) {
+		t.Fatal("unexpected slice", s, err)
+	}
+	m, err := serialization.UnPack(receiver, reflect.TypeOf(map[string]int{}))
+	if err != nil || m.(map[string]int)["a"] != 1 {
+		t.Fatal("unexpected map", m, err)
+	}
+	_, err = receiver.ReadByte()
+	if err != io.EOF {
+		t.Fatal("expected EOF", err)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	server, client := net.Pipe()
+	sender := new(BufferTcpConn)
+	sender.initBuffers(client)
+	receiver := new(BufferTcpConn)
+	receiver.initBuffers(server)
+
+	go func() {
+		_ = sender.TCPWriteFrame([]byte("hello"))
+		_ = sender.TCPWriteFrame(make([]byte, 100))
+		_ = sender.Close()
+	}()
+
+	frame, err := receiver.TCPReadFrame(64)
+	if err != nil || string(frame) != "hello" {
+		t.Fatal("unexpected frame", string(frame), err)
+	}
+	_, err = receiver.TCPReadFrame(64)
+	if err == nil {
+		t.Fatal("expected frame size error")
+	}
+}
+
+func TestSetConfigLive(t *testing.T) {
+	server, client := net.Pipe()
+	sender := new(BufferTcpConn)
+	sender.TCPAttach(client)
+	receiver := new(BufferTcpConn)
+	receiver.TCPAttach(server)
+
+	// a partial config on a live connection keeps the defaults of the other fields
+	sender.SetConfig(BufferTcpConfig{ReadChunkSize: 8})
+	receiver.SetConfig(BufferTcpConfig{ReadChunkSize: 8})
+	sent := make(chan error, 1)
+	go func() {
+		_ = sender.TCPWrite([]byte("1234567890abcdefg"))
+		sent <- sender.TCPFlush()
+	}()
+	buffer, n, _, err := receiver.TCPRead(17)
+	if err != nil || n != 17 || string(buffer) != "1234567890abcdefg" {
+		t.Fatal("unexpected read", string(buffer), n, err)
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.Close()
+	_ = receiver.Close()
 }
@@ -0,0 +1,224 @@
+package buffer_udp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mutalisk999/go-lib/src/io/serialization"
+	"net"
+	"strconv"
+)
+
+// DefaultMTU is the datagram payload size which avoids IP fragmentation on common links
+const DefaultMTU = 1400
+
+// every message in a datagram is framed like buffer_tcp frames: uint32 length + payload
+const frameHeaderSize = 4
+
+func appendMessage(datagram []byte, message []byte) []byte {
+	buf := bytes.NewBuffer(datagram)
+	_ = serialization.PackBytes(buf, message)
+	return buf.Bytes()
+}
+
+func splitMessages(datagram []byte) ([][]byte, error) {
+	reader := bytes.NewReader(datagram)
+	messages := make([][]byte, 0)
+	for reader.Len() > 0 {
+		if reader.Len() < frameHeaderSize {
+			return messages, errors.New("splitMessages: truncated frame header")
+		}
+		// the length comes from the network, it is checked before allocating
+		messageLen, err := serialization.UnPackUint32(reader)
+		if err != nil {
+			return messages, errors.New("splitMessages: truncated frame header")
+		}
+		if uint64(messageLen) > uint64(reader.Len()) {
+			return messages, errors.New("splitMessages: truncated frame")
+		}
+		message := make([]byte, messageLen)
+		_, _ = reader.Read(message)
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func checkMessageSize(message []byte, mtu int) error {
+	if len(message)+frameHeaderSize > mtu {
+		return errors.New("message size exceeds mtu: " + strconv.Itoa(len(message)))
+	}
+	return nil
+}
+
+// batchMessages packs the messages into as few datagrams of at most mtu bytes as possible, keeping their order
+func batchMessages(messages [][]byte, mtu int) ([][]byte, error) {
+	datagrams := make([][]byte, 0)
+	datagram := make([]byte, 0, mtu)
+	for _, message := range messages {
+		err := checkMessageSize(message, mtu)
+		if err != nil {
+			return nil, err
+		}
+		if len(datagram)+len(message)+frameHeaderSize > mtu {
+			datagrams = append(datagrams, datagram)
+			datagram = make([]byte, 0, mtu)
+		}
+		datagram = appendMessage(datagram, message)
+	}
+	if len(datagram) > 0 {
+		datagrams = append(datagrams, datagram)
+	}
+	return datagrams, nil
+}
+
+func packMessage(argPack interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := serialization.Pack(buf, argPack)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type BufferUdpConn struct {
+	conn       *net.UDPConn
+	mtu        int
+	sendBuffer []byte
+}
+
+func (c *BufferUdpConn) UDPConnect(serverAddr string, serverPort uint16) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", serverAddr+":"+strconv.Itoa(int(serverPort)))
+	if err != nil {
+		return err
+	}
+	client, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return err
+	}
+	c.conn = client
+	if c.mtu == 0 {
+		c.mtu = DefaultMTU
+	}
+	c.sendBuffer = make([]byte, 0, c.mtu)
+	return nil
+}
+
+// SetMTU sets the largest datagram payload sent by the connection
+func (c *BufferUdpConn) SetMTU(mtu int) {
+	c.mtu = mtu
+}
+
+// UDPWriteMessage batches the message into the pending datagram, which is sent first if the message does not fit
+func (c *BufferUdpConn) UDPWriteMessage(message []byte) error {
+	err := checkMessageSize(message, c.mtu)
+	if err != nil {
+		return err
+	}
+	if len(c.sendBuffer)+len(message)+frameHeaderSize > c.mtu {
+		err = c.UDPFlush()
+		if err != nil {
+			return err
+		}
+	}
+	c.sendBuffer = appendMessage(c.sendBuffer, message)
+	return nil
+}
+
+// UDPWritePack serializes argPack with serialization.Pack and batches it as one message
+func (c *BufferUdpConn) UDPWritePack(argPack interface{}) error {
+	message, err := packMessage(argPack)
+	if err != nil {
+		return err
+	}
+	return c.UDPWriteMessage(message)
+}
+
+func (c *BufferUdpConn) UDPFlush() error {
+	if len(c.sendBuffer) == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(c.sendBuffer)
+	c.sendBuffer = c.sendBuffer[:0]
+	return err
+}
+
+// UDPReadMessages receives one datagram and returns the messages batched in it
+func (c *BufferUdpConn) UDPReadMessages() ([][]byte, error) {
+	buf := make([]byte, 65536)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return splitMessages(buf[0:n])
+}
+
+func (c *BufferUdpConn) UDPDisConnect() error {
+	err := c.UDPFlush()
+	_ = c.conn.Close()
+	return err
+}
+
+type UdpListener struct {
+	udpAddr *net.UDPAddr
+	conn    *net.UDPConn
+	mtu     int
+}
+
+func (l *UdpListener) UDPListen(udpAddr *net.UDPAddr) error {
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return err
+	}
+	l.udpAddr = udpAddr
+	l.conn = conn
+	if l.mtu == 0 {
+		l.mtu = DefaultMTU
+	}
+	return nil
+}
+
+// SetMTU sets the largest datagram payload sent by UDPSendMessages
+func (l *UdpListener) SetMTU(mtu int) {
+	l.mtu = mtu
+}
+
+func (l *UdpListener) Addr() net.Addr {
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.LocalAddr()
+}
+
+// UDPReceive receives one datagram and returns its messages and sender
+func (l *UdpListener) UDPReceive() ([][]byte, *net.UDPAddr, error) {
+	if l.conn == nil {
+		return nil, nil, errors.New("invalid listener")
+	}
+	buf := make([]byte, 65536)
+	n, remoteAddr, err := l.conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	messages, err := splitMessages(buf[0:n])
+	return messages, remoteAddr, err
+}
+
+// UDPSendMessages sends the messages to remoteAddr batched into as few datagrams as possible
+func (l *UdpListener) UDPSendMessages(remoteAddr *net.UDPAddr, messages [][]byte) error {
+	datagrams, err := batchMessages(messages, l.mtu)
+	if err != nil {
+		return err
+	}
+	for _, datagram := range datagrams {
+		_, err = l.conn.WriteToUDP(datagram, remoteAddr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *UdpListener) UDPListenClose() {
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+}
@@ -0,0 +1,91 @@
+package buffer_udp
+
+import (
+	"bytes"
+	"github.com/mutalisk999/go-lib/src/io/serialization"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestBatchMessages(t *testing.T) {
+	messages := [][]byte{make([]byte, 10), make([]byte, 10), make([]byte, 20), make([]byte, 5)}
+	datagrams, err := batchMessages(messages, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datagrams) != 3 || len(datagrams[0]) != 28 || len(datagrams[1]) != 24 || len(datagrams[2]) != 9 {
+		t.Fatal("unexpected batching", len(datagrams))
+	}
+	_, err = batchMessages([][]byte{make([]byte, 29)}, 32)
+	if err == nil {
+		t.Fatal("expected mtu error")
+	}
+	_, err = splitMessages([]byte{10, 0, 0, 0, 1})
+	if err == nil {
+		t.Fatal("expected truncated frame error")
+	}
+}
+
+func TestSplitForgedLength(t *testing.T) {
+	// a 4 GiB length must fail without allocating it
+	messages, err := splitMessages([]byte{2, 0, 0, 0, 'o', 'k', 0xff, 0xff, 0xff, 0xff})
+	if err == nil || len(messages) != 1 || string(messages[0]) != "ok" {
+		t.Fatal("expected the forged length to be rejected", messages, err)
+	}
+	messages, err = splitMessages([]byte{0, 0, 0, 0})
+	if err != nil || len(messages) != 1 || len(messages[0]) != 0 {
+		t.Fatal("unexpected empty message", messages, err)
+	}
+}
+
+func TestUdpRoundTrip(t *testing.T) {
+	listener := new(UdpListener)
+	err := listener.UDPListen(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.UDPListenClose()
+
+	client := new(BufferUdpConn)
+	client.SetMTU(64)
+	err = client.UDPConnect("127.0.0.1", uint16(listener.Addr().(*net.UDPAddr).Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.UDPDisConnect()
+
+	_ = client.UDPWriteMessage([]byte("cpu=1"))
+	_ = client.UDPWriteMessage([]byte("mem=2"))
+	_ = client.UDPWritePack([]string{"disk", "net"})
+	_ = client.UDPFlush()
+
+	messages, remoteAddr, err := listener.UDPReceive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || string(messages[0]) != "cpu=1" || string(messages[1]) != "mem=2" {
+		t.Fatal("unexpected messages", messages)
+	}
+	unpacked, err := serialization.UnPack(bytes.NewReader(messages[2]), reflect.TypeOf([]string{}))
+	if err != nil || !reflect.DeepEqual(unpacked, []string{"disk", "net"}) {
+		t.Fatal("unexpected packed message", unpacked, err)
+	}
+
+	err = client.UDPWriteMessage(make([]byte, 61))
+	if err == nil {
+		t.Fatal("expected mtu error")
+	}
+
+	replies := [][]byte{make([]byte, 1000), make([]byte, 1000)}
+	err = listener.UDPSendMessages(remoteAddr, replies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		messages, err = client.UDPReadMessages()
+		if err != nil || len(messages) != 1 || len(messages[0]) != 1000 {
+			t.Fatal("unexpected reply", len(messages), err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package jsonrpc_cli
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+type FailoverConfig struct {
+	// tried in order, "host:port" or "tcp://host:port" for newline delimited JSON over TCP,
+	// ws:// and wss:// urls for websockets
+	Endpoints []string
+	// timeout of connecting in seconds
+	TimeOut float64
+	// headers of the websocket handshakes
+	Headers map[string]string
+	// seconds before reconnecting once the connection was lost, 0 uses 1 second
+	ReconnectInterval float64
+	// the delay doubles after every round of failed endpoints up to MaxReconnectInterval,
+	// 0 keeps ReconnectInterval
+	MaxReconnectInterval float64
+	// methods which are safe to call again, they are retried once reconnected
+	// when they failed with a TransportErrorCode error object
+	IdempotentMethods []string
+	// retries per call of IdempotentMethods, 0 uses the number of endpoints
+	MaxRetries int
+}
+
+// failoverState is shared by the copies of a client connected with reconnecting
+type failoverState struct {
+	transport  *reconnectTransport
+	idempotent map[string]bool
+	maxRetries int
+}
+
+func tcpEndpoint(address string, timeOut float64) endpoint {
+	return endpoint{address: address, dial: func() (transport, error) {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(address, "tcp://"),
+			time.Duration(timeOut*1000*1000*1000))
+		if err != nil {
+			return nil, err
+		}
+		return newTcpTransport(conn), nil
+	}}
+}
+
+// RpcConnectFailover connects to the first available of config.Endpoints speaking JSON-RPC 2.0.
+// A lost connection is dialed again in the background, moving on to the other endpoints,
+// and the calls waiting meanwhile fail with a TransportErrorCode error object unless they are retried.
+// Subscriptions are made again after reconnecting.
+func (g *RpcClient) RpcConnectFailover(config FailoverConfig) error {
+	endpoints := make([]endpoint, 0, len(config.Endpoints))
+	for _, address := range config.Endpoints {
+		if strings.HasPrefix(address, "ws://") || strings.HasPrefix(address, "wss://") {
+			endpoints = append(endpoints, wsEndpoint(WsConfig{URL: address, Headers: config.Headers, TimeOut: config.TimeOut}))
+		} else if strings.Contains(address, "://") && !strings.HasPrefix(address, "tcp://") {
+			return errors.New("unsupported endpoint " + address)
+		} else {
+			endpoints = append(endpoints, tcpEndpoint(address, config.TimeOut))
+		}
+	}
+	reconnectInterval := config.ReconnectInterval
+	if reconnectInterval <= 0 {
+		reconnectInterval = 1
+	}
+	t, err := newReconnectTransport(endpoints, reconnectInterval, config.MaxReconnectInterval)
+	if err != nil {
+		return err
+	}
+
+	failover := new(failoverState)
+	failover.transport = t
+	failover.idempotent = make(map[string]bool)
+	for _, method := range config.IdempotentMethods {
+		failover.idempotent[method] = true
+	}
+	failover.maxRetries = config.MaxRetries
+	if failover.maxRetries <= 0 {
+		failover.maxRetries = len(endpoints)
+	}
+	g.connectReconnecting(failover)
+	return nil
+}
+
+func (g *RpcClient) connectReconnecting(failover *failoverState) {
+	g.rpcConn = nil
+	g.failover = failover
+	g.codec = newClientCodec(failover.transport)
+	g.initSubscriptions()
+	failover.transport.onReconnect = g.subscriptions.resubscribe
+	g.rpcCli = rpc.NewClientWithCodec(g.codec)
+	g.subscriptions.setClient(g)
+	failover.transport.start()
+}
+
+// ConnectedEndpoint is the endpoint of RpcConnectFailover or RpcConnectWs the client is connected to,
+// empty while reconnecting
+func (g RpcClient) ConnectedEndpoint() string {
+	if g.failover == nil {
+		return ""
+	}
+	return g.failover.transport.address()
+}
+
+// shouldRetry tells a call of method failed on the connection and may be sent again
+func (f *failoverState) shouldRetry(method string, err error, retries int) bool {
+	if f == nil || !f.idempotent[method] || retries >= f.maxRetries {
+		return false
+	}
+	rpcErr, ok := err.(*RpcError)
+	return ok && rpcErr.Code == TransportErrorCode
+}
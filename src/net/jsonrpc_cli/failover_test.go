@@ -0,0 +1,120 @@
+package jsonrpc_cli
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves like startJsonRpc2Server and can drop its connections
+type testServer struct {
+	listener net.Listener
+	mutex    *sync.Mutex
+	conns    []net.Conn
+}
+
+func startTestServer(t *testing.T, address string) *testServer {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, mutex: new(sync.Mutex), conns: make([]net.Conn, 0)}
+	notifications := make(chan testRequest, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go serveJsonRpc2(conn, notifications)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) kill() {
+	_ = s.listener.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func waitEndpoint(t *testing.T, rpcClient *RpcClient, address string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for rpcClient.ConnectedEndpoint() != address {
+		if time.Now().After(deadline) {
+			t.Fatal("not connected to", address, rpcClient.ConnectedEndpoint())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	first := startTestServer(t, "127.0.0.1:0")
+	second := startTestServer(t, "127.0.0.1:0")
+	defer second.kill()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectFailover(FailoverConfig{Endpoints: []string{"127.0.0.1:1", first.address(), "tcp://" + second.address()},
+		TimeOut: 1, ReconnectInterval: 0.05, MaxReconnectInterval: 0.2, IdempotentMethods: []string{"slow"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+	if rpcClient.ConnectedEndpoint() != first.address() {
+		t.Fatal("expected the first available endpoint", rpcClient.ConnectedEndpoint())
+	}
+
+	// the idempotent call lost with the first server is answered by the second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.kill()
+	}()
+	result, err := rpcClient.RpcRequest("slow", nil)
+	if err != nil || result != "late" {
+		t.Fatal("expected the call to be retried", result, err)
+	}
+	if rpcClient.ConnectedEndpoint() != "tcp://"+second.address() {
+		t.Fatal("expected to fail over to the second server", rpcClient.ConnectedEndpoint())
+	}
+
+	// other calls are not retried
+	address := second.address()
+	second.kill()
+	_, err = rpcClient.RpcRequest("echo", nil)
+	rpcErr, ok := err.(*RpcError)
+	if !ok || rpcErr.Code != TransportErrorCode {
+		t.Fatal("expected a transport error", err)
+	}
+
+	// all endpoints are tried until one is back
+	restarted := startTestServer(t, address)
+	defer restarted.kill()
+	waitEndpoint(t, rpcClient, "tcp://"+address)
+	result, err = rpcClient.RpcRequest("echo", []int{1})
+	if err != nil {
+		t.Fatal("client unusable after reconnecting", result, err)
+	}
+}
+
+func TestFailoverEndpoints(t *testing.T) {
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectFailover(FailoverConfig{Endpoints: []string{"127.0.0.1:1"}, TimeOut: 1})
+	if err == nil {
+		t.Fatal("expected connecting to fail without an available endpoint")
+	}
+	err = rpcClient.RpcConnectFailover(FailoverConfig{Endpoints: []string{"http://127.0.0.1:1"}, TimeOut: 1})
+	if err == nil {
+		t.Fatal("expected http endpoints to be refused")
+	}
+}
@@ -0,0 +1,196 @@
+package jsonrpc_cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TransportErrorCode is the error object code of calls the transport failed to deliver,
+// e.g. on a network error or an HTTP status without a JSON-RPC body
+const TransportErrorCode = -32099
+
+var errEmptyResponse = errors.New("empty http response")
+
+type HttpConfig struct {
+	URL     string
+	Headers map[string]string
+	// basic auth is used when BasicUser is set, bearer auth when BearerToken is set
+	BasicUser     string
+	BasicPassword string
+	BearerToken   string
+	// per request timeout in seconds, 0 leaves it to the http.Client
+	TimeOut float64
+	// shared http.Client, nil uses http.DefaultClient
+	Client *http.Client
+}
+
+// httpTransport posts every message as its own HTTP request and queues the response bodies
+type httpTransport struct {
+	config    HttpConfig
+	client    *http.Client
+	responses chan []byte
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func newHttpTransport(config HttpConfig) *httpTransport {
+	t := new(httpTransport)
+	t.config = config
+	t.client = config.Client
+	if t.client == nil {
+		t.client = http.DefaultClient
+	}
+	t.responses = make(chan []byte, 16)
+	t.done = make(chan struct{})
+	t.closeOnce = new(sync.Once)
+	return t
+}
+
+type httpHeadersKey struct{}
+
+// WithHttpHeaders returns a context whose calls over RpcConnectHttp carry headers,
+// set over the configured ones. Interceptors may use it to add e.g. per call auth.
+func WithHttpHeaders(ctx context.Context, headers http.Header) context.Context {
+	merged := http.Header{}
+	previous, ok := ctx.Value(httpHeadersKey{}).(http.Header)
+	if ok {
+		merged = previous.Clone()
+	}
+	for key, values := range headers {
+		merged[http.CanonicalHeaderKey(key)] = append([]string{}, values...)
+	}
+	return context.WithValue(ctx, httpHeadersKey{}, merged)
+}
+
+func (t *httpTransport) writeMessage(message []byte) error {
+	return t.writeMessageContext(context.Background(), message)
+}
+
+// writeMessageContext posts the message with the context of its call, cancelling the call cancels the request
+func (t *httpTransport) writeMessageContext(ctx context.Context, message []byte) error {
+	select {
+	case <-t.done:
+		return rpc.ErrShutdown
+	default:
+	}
+	go t.post(ctx, message)
+	return nil
+}
+
+func (t *httpTransport) post(ctx context.Context, message []byte) {
+	response, err := t.roundTrip(ctx, message)
+	if err == nil && len(response) == 0 {
+		// only a message of notifications may go unanswered
+		err = errEmptyResponse
+	}
+	if err != nil {
+		response = transportErrorResponse(message, err)
+	}
+	if len(response) == 0 {
+		return
+	}
+	select {
+	case t.responses <- response:
+	case <-t.done:
+	}
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, message []byte) ([]byte, error) {
+	if t.config.TimeOut > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t.config.TimeOut*1000*1000*1000))
+		defer cancel()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.URL, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	for key, value := range t.config.Headers {
+		request.Header.Set(key, value)
+	}
+	if t.config.BasicUser != "" {
+		request.SetBasicAuth(t.config.BasicUser, t.config.BasicPassword)
+	} else if t.config.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+t.config.BearerToken)
+	}
+	headers, ok := ctx.Value(httpHeadersKey{}).(http.Header)
+	if ok {
+		for key, values := range headers {
+			request.Header[key] = values
+		}
+	}
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	// servers may answer errors with a non 2xx status and a JSON-RPC body
+	if len(body) > 0 && json.Valid(body) && (body[0] == '{' || body[0] == '[') {
+		return body, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, errors.New("http status " + strconv.Itoa(response.StatusCode))
+	}
+	if len(body) > 0 {
+		return nil, errors.New("invalid JSON-RPC response body")
+	}
+	return nil, nil
+}
+
+func (t *httpTransport) readMessage() ([]byte, error) {
+	select {
+	case response := <-t.responses:
+		return response, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *httpTransport) close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+	return nil
+}
+
+// transportErrorResponse answers every call of a message with a TransportErrorCode error object
+func transportErrorResponse(message []byte, err error) []byte {
+	var requests []jsonResponse
+	if json.Unmarshal(message, &requests) != nil {
+		var request jsonResponse
+		if json.Unmarshal(message, &request) != nil {
+			return nil
+		}
+		requests = []jsonResponse{request}
+	}
+	rpcErr, _ := json.Marshal(&RpcError{Code: TransportErrorCode, Message: err.Error()})
+	responses := make([]jsonResponse, 0, len(requests))
+	for _, request := range requests {
+		if len(request.Id) == 0 {
+			continue
+		}
+		responses = append(responses, jsonResponse{Version: JsonRpcVersion, Id: request.Id, Error: rpcErr})
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	response, _ := json.Marshal(responses)
+	return response
+}
@@ -0,0 +1,165 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func jsonRpc2HttpHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user, password, ok := r.BasicAuth()
+		bearer := r.Header.Get("Authorization") == "Bearer token"
+		if !bearer && (!ok || user != "user" || password != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request testRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if request.Id == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		switch request.Method {
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		case "empty":
+			w.WriteHeader(http.StatusOK)
+			return
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id,
+				"error": map[string]interface{}{"code": -32601, "message": "method not found"}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id,
+			"result": map[string]interface{}{"params": request.Params, "header": r.Header.Get("X-Trace")}})
+	}
+}
+
+func TestHttpTransport(t *testing.T) {
+	server := httptest.NewServer(jsonRpc2HttpHandler(t))
+	defer server.Close()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectHttp(HttpConfig{URL: server.URL, BasicUser: "user", BasicPassword: "secret",
+		Headers: map[string]string{"X-Trace": "abc"}, TimeOut: 0.1, Client: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := rpcClient.RpcRequest("echo", []int{i})
+			expect := map[string]interface{}{"params": []interface{}{float64(i)}, "header": "abc"}
+			if err != nil || !reflect.DeepEqual(result, expect) {
+				t.Error("unexpected result", result, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = rpcClient.RpcRequest("fail", nil)
+	rpcErr, ok := err.(*RpcError)
+	if !ok || rpcErr.Code != -32601 {
+		t.Fatal("expected the error object of a 500 response", err)
+	}
+	for _, method := range []string{"unavailable", "slow", "empty"} {
+		_, err = rpcClient.RpcRequest(method, nil)
+		rpcErr, ok = err.(*RpcError)
+		if !ok || rpcErr.Code != TransportErrorCode {
+			t.Fatal("expected a transport error", method, err)
+		}
+	}
+	err = rpcClient.RpcNotify("log", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rpcClient.RpcRequest("echo", nil)
+	if err != nil {
+		t.Fatal("client unusable after transport errors", err)
+	}
+}
+
+func TestHttpTransportAuth(t *testing.T) {
+	server := httptest.NewServer(jsonRpc2HttpHandler(t))
+	defer server.Close()
+
+	_, err := RpcRequestSimpleHttp(HttpConfig{URL: server.URL, BearerToken: "token"}, "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RpcRequestSimpleHttp(HttpConfig{URL: server.URL, BearerToken: "wrong"}, "echo", nil)
+	rpcErr, ok := err.(*RpcError)
+	if !ok || rpcErr.Code != TransportErrorCode || rpcErr.Message != "http status 401" {
+		t.Fatal("expected an unauthorized transport error", err)
+	}
+}
+
+func TestHttpTransportContext(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request testRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if request.Method == "block" {
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+			case <-time.After(2 * time.Second):
+			}
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id,
+			"result": r.Header.Get("Authorization") + "|" + r.Header.Get("X-Trace")})
+	}))
+	defer server.Close()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectHttp(HttpConfig{URL: server.URL, BearerToken: "static",
+		Headers: map[string]string{"X-Trace": "config"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+	rpcClient.Use(func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		ctx = WithHttpHeaders(ctx, http.Header{"Authorization": {"Bearer " + method}})
+		return next(WithHttpHeaders(ctx, http.Header{"x-trace": {"call"}}), method, params, reply)
+	})
+
+	result, err := rpcClient.RpcRequest("echo", nil)
+	if err != nil || result != "Bearer echo|call" {
+		t.Fatal("expected the headers of the interceptor", result, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = rpcClient.RpcRequestContext(ctx, "block", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected the deadline to end the call", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be cancelled with its call")
+	}
+}
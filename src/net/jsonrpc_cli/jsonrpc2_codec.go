@@ -0,0 +1,407 @@
+package jsonrpc_cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/rpc"
+	"strconv"
+	"sync"
+)
+
+const JsonRpcVersion = "2.0"
+
+// RpcError is the error object of a JSON-RPC 2.0 response
+type RpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RpcError) Error() string {
+	return "rpc error " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// errConnectionLost is returned by the readMessage of transports which reconnect on their own,
+// the calls waiting for a response are failed with a TransportErrorCode error object
+var errConnectionLost = errors.New("connection lost")
+
+// transport moves whole JSON messages, a message may hold a batch array
+type transport interface {
+	writeMessage(message []byte) error
+	readMessage() ([]byte, error)
+	close() error
+}
+
+// contextTransport is implemented by transports which tie a message to the context of its call,
+// e.g. to cancel its HTTP request
+type contextTransport interface {
+	writeMessageContext(ctx context.Context, message []byte) error
+}
+
+// callArg carries the context of a call through net/rpc to WriteRequest,
+// and the batch collecting the request if any
+type callArg struct {
+	ctx   context.Context
+	arg   interface{}
+	batch *pendingBatch
+}
+
+// pendingBatch collects the messages of one RpcBatch, written as one array by endBatch
+type pendingBatch struct {
+	messages [][]byte
+	ids      []uint64
+}
+
+type tcpTransport struct {
+	conn    net.Conn
+	decoder *json.Decoder
+}
+
+func newTcpTransport(conn net.Conn) *tcpTransport {
+	t := new(tcpTransport)
+	t.conn = conn
+	t.decoder = json.NewDecoder(conn)
+	return t
+}
+
+func (t *tcpTransport) writeMessage(message []byte) error {
+	_, err := t.conn.Write(append(message, '\n'))
+	return err
+}
+
+func (t *tcpTransport) readMessage() ([]byte, error) {
+	var message json.RawMessage
+	err := t.decoder.Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (t *tcpTransport) close() error {
+	return t.conn.Close()
+}
+
+type jsonRequest struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	Id      *uint64     `json:"id,omitempty"`
+}
+
+type jsonResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// encodeParams passes arrays and objects as positional or named params, other values become a single positional param
+func encodeParams(arg interface{}) (interface{}, error) {
+	if arg == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(arg)
+	if err != nil {
+		return nil, err
+	}
+	encoded = bytes.TrimSpace(encoded)
+	if bytes.Equal(encoded, []byte("null")) {
+		return nil, nil
+	}
+	if encoded[0] == '[' || encoded[0] == '{' {
+		return json.RawMessage(encoded), nil
+	}
+	return []json.RawMessage{encoded}, nil
+}
+
+// EncodeParams returns the params a JSON-RPC 2.0 client sends for arg, null for none
+func EncodeParams(arg interface{}) (json.RawMessage, error) {
+	params, err := encodeParams(arg)
+	if err != nil || params == nil {
+		return json.RawMessage("null"), err
+	}
+	return json.Marshal(params)
+}
+
+func encodeRequest(method string, arg interface{}, id *uint64) ([]byte, error) {
+	params, err := encodeParams(arg)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonRequest{Version: JsonRpcVersion, Method: method, Params: params, Id: id})
+}
+
+// parseId accepts numeric ids and numbers sent back as strings
+func parseId(id json.RawMessage) (uint64, bool) {
+	// null decodes into any value without error
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 || bytes.Equal(id, []byte("null")) {
+		return 0, false
+	}
+	var seq uint64
+	if json.Unmarshal(id, &seq) == nil {
+		return seq, true
+	}
+	var text string
+	if json.Unmarshal(id, &text) == nil {
+		seq, err := strconv.ParseUint(text, 10, 64)
+		return seq, err == nil
+	}
+	return 0, false
+}
+
+// clientCodec speaks JSON-RPC 2.0 for net/rpc, error objects travel as their JSON in rpc.ServerError
+type clientCodec struct {
+	transport  transport
+	writeMutex *sync.Mutex
+	queued     []json.RawMessage
+	result     json.RawMessage
+	// ids of the calls written and not answered yet
+	pendingMutex *sync.Mutex
+	pending      map[uint64]bool
+	// ids of batch calls whose write failed, answered with an error on the next read
+	failedWrites   []uint64
+	onNotification func(method string, params json.RawMessage)
+	// called once reading failed for good
+	onDisconnect func(err error)
+}
+
+func newClientCodec(t transport) *clientCodec {
+	c := new(clientCodec)
+	c.transport = t
+	c.writeMutex = new(sync.Mutex)
+	c.queued = make([]json.RawMessage, 0)
+	c.pendingMutex = new(sync.Mutex)
+	c.pending = make(map[uint64]bool)
+	return c
+}
+
+func (c *clientCodec) write(ctx context.Context, message []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeTransport(ctx, message)
+}
+
+func (c *clientCodec) writeTransport(ctx context.Context, message []byte) error {
+	t, ok := c.transport.(contextTransport)
+	if ok {
+		return t.writeMessageContext(ctx, message)
+	}
+	return c.transport.writeMessage(message)
+}
+
+// endBatch sends the messages of b as one array tied to ctx. When that fails, only the calls
+// of b are failed, net/rpc gets their error responses on the next read.
+func (c *clientCodec) endBatch(ctx context.Context, b *pendingBatch) error {
+	if len(b.messages) == 0 {
+		return nil
+	}
+	err := c.write(ctx, append(append([]byte{'['}, bytes.Join(b.messages, []byte{','})...), ']'))
+	if err != nil {
+		c.pendingMutex.Lock()
+		for _, id := range b.ids {
+			delete(c.pending, id)
+		}
+		c.failedWrites = append(c.failedWrites, b.ids...)
+		c.pendingMutex.Unlock()
+	}
+	return err
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	ctx := context.Background()
+	var batch *pendingBatch
+	wrapped, ok := body.(*callArg)
+	if ok {
+		ctx, body, batch = wrapped.ctx, wrapped.arg, wrapped.batch
+	}
+	id := r.Seq
+	message, err := encodeRequest(r.ServiceMethod, body, &id)
+	if err != nil {
+		return err
+	}
+	c.pendingMutex.Lock()
+	c.pending[id] = true
+	c.pendingMutex.Unlock()
+	if batch != nil {
+		batch.messages = append(batch.messages, message)
+		batch.ids = append(batch.ids, id)
+		return nil
+	}
+	err = c.write(ctx, message)
+	if err != nil {
+		c.pendingMutex.Lock()
+		delete(c.pending, id)
+		c.pendingMutex.Unlock()
+	}
+	return err
+}
+
+// notify sends a notification, or adds it to batch when that is not nil
+func (c *clientCodec) notify(method string, arg interface{}, batch *pendingBatch) error {
+	message, err := encodeRequest(method, arg, nil)
+	if err != nil {
+		return err
+	}
+	if batch != nil {
+		batch.messages = append(batch.messages, message)
+		return nil
+	}
+	return c.write(context.Background(), message)
+}
+
+// nextMessage returns the next single response, splitting batch arrays
+func (c *clientCodec) nextMessage() (json.RawMessage, error) {
+	for len(c.queued) == 0 {
+		c.queueFailedWrites()
+		if len(c.queued) > 0 {
+			break
+		}
+		message, err := c.transport.readMessage()
+		if err == errConnectionLost {
+			c.failPending(err)
+			continue
+		}
+		if err != nil {
+			if c.onDisconnect != nil {
+				c.onDisconnect(err)
+			}
+			return nil, err
+		}
+		message = bytes.TrimSpace(message)
+		if len(message) == 0 {
+			continue
+		}
+		if message[0] == '[' {
+			var batch []json.RawMessage
+			err = json.Unmarshal(message, &batch)
+			if err != nil {
+				return nil, err
+			}
+			c.queued = append(c.queued, batch...)
+		} else {
+			c.queued = append(c.queued, message)
+		}
+	}
+	message := c.queued[0]
+	c.queued = c.queued[1:]
+	return message, nil
+}
+
+// failPending queues TransportErrorCode responses for the calls which were waiting on a lost connection
+func (c *clientCodec) failPending(err error) {
+	rpcErr, _ := json.Marshal(&RpcError{Code: TransportErrorCode, Message: err.Error()})
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	for id := range c.pending {
+		response, _ := json.Marshal(jsonResponse{Version: JsonRpcVersion,
+			Id: json.RawMessage(strconv.FormatUint(id, 10)), Error: rpcErr})
+		c.queued = append(c.queued, response)
+	}
+	c.pending = make(map[uint64]bool)
+}
+
+// queueFailedWrites queues TransportErrorCode responses for the batch calls which could not be written
+func (c *clientCodec) queueFailedWrites() {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	if len(c.failedWrites) == 0 {
+		return
+	}
+	rpcErr, _ := json.Marshal(&RpcError{Code: TransportErrorCode, Message: "batch write failed"})
+	for _, id := range c.failedWrites {
+		response, _ := json.Marshal(jsonResponse{Version: JsonRpcVersion,
+			Id: json.RawMessage(strconv.FormatUint(id, 10)), Error: rpcErr})
+		c.queued = append(c.queued, response)
+	}
+	c.failedWrites = nil
+}
+
+func (c *clientCodec) oldestPending() (uint64, bool) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	oldest, found := uint64(0), false
+	for id := range c.pending {
+		if !found || id < oldest {
+			oldest, found = id, true
+		}
+	}
+	return oldest, found
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	for {
+		message, err := c.nextMessage()
+		if err != nil {
+			return err
+		}
+		var response jsonResponse
+		err = json.Unmarshal(message, &response)
+		if err != nil {
+			return err
+		}
+		hasError := len(response.Error) > 0 && !bytes.Equal(response.Error, []byte("null"))
+		seq, ok := parseId(response.Id)
+		if !ok && response.Method != "" {
+			// server notifications carry a method
+			if c.onNotification != nil {
+				c.onNotification(response.Method, response.Params)
+			}
+			continue
+		}
+		if !ok {
+			// servers answer requests they could not parse with a null id, the oldest call gets the error
+			seq, ok = c.oldestPending()
+			if !ok || !hasError {
+				continue
+			}
+		}
+
+		c.pendingMutex.Lock()
+		delete(c.pending, seq)
+		c.pendingMutex.Unlock()
+		r.Seq = seq
+		r.Error = ""
+		c.result = response.Result
+		if hasError {
+			r.Error = string(response.Error)
+		}
+		return nil
+	}
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	result := c.result
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+	return json.Unmarshal(result, body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.transport.close()
+}
+
+// toRpcError turns the error objects carried by rpc.ServerError back into *RpcError
+func toRpcError(err error) error {
+	serverErr, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	rpcErr := new(RpcError)
+	if json.Unmarshal([]byte(serverErr), rpcErr) != nil {
+		return &RpcError{Code: -32603, Message: string(serverErr)}
+	}
+	return rpcErr
+}
+
+var _ rpc.ClientCodec = (*clientCodec)(nil)
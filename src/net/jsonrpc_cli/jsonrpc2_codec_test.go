@@ -0,0 +1,181 @@
+package jsonrpc_cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// startJsonRpc2Server answers every request with its params, "fail" with an error object
+// and forwards notifications to the returned channel
+func startJsonRpc2Server(t *testing.T) (uint16, chan testRequest, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications := make(chan testRequest, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveJsonRpc2(conn, notifications)
+		}
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port), notifications, func() {
+		_ = listener.Close()
+	}
+}
+
+func serveJsonRpc2(conn net.Conn, notifications chan testRequest) {
+	defer conn.Close()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		var message json.RawMessage
+		if decoder.Decode(&message) != nil {
+			return
+		}
+		if message[0] != '[' {
+			var request testRequest
+			_ = json.Unmarshal(message, &request)
+			response := handleJsonRpc2(request, encoder, notifications)
+			if response != nil {
+				_ = encoder.Encode(response)
+			}
+			continue
+		}
+		// batches are answered in reverse order
+		var requests []testRequest
+		_ = json.Unmarshal(message, &requests)
+		responses := make([]interface{}, 0)
+		for i := len(requests) - 1; i >= 0; i-- {
+			response := handleJsonRpc2(requests[i], encoder, notifications)
+			if response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) > 0 {
+			_ = encoder.Encode(responses)
+		}
+	}
+}
+
+func handleJsonRpc2(request testRequest, encoder *json.Encoder, notifications chan testRequest) interface{} {
+	if request.Id == nil {
+		notifications <- request
+		return nil
+	}
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": request.Id}
+	switch request.Method {
+	case "fail":
+		response["error"] = map[string]interface{}{"code": -32000, "message": "failed", "data": map[string]int{"retry": 3}}
+	case "invalid":
+		// answered like a request the server could not parse
+		response["id"] = nil
+		response["error"] = map[string]interface{}{"code": -32600, "message": "invalid request"}
+	case "slow":
+		time.Sleep(200 * time.Millisecond)
+		response["result"] = "late"
+	case "push":
+		// a server notification arrives ahead of the response
+		_ = encoder.Encode(map[string]interface{}{"jsonrpc": "2.0", "method": "pushed", "params": []int{1}})
+		response["result"] = true
+	default:
+		response["result"] = request.Params
+	}
+	return response
+}
+
+func TestJsonRpc2Request(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	for _, c := range []struct {
+		arg    interface{}
+		expect interface{}
+	}{
+		{[]interface{}{1, "two"}, []interface{}{float64(1), "two"}},
+		{map[string]int{"a": 1}, map[string]interface{}{"a": float64(1)}},
+		{struct {
+			Name string `json:"name"`
+		}{"x"}, map[string]interface{}{"name": "x"}},
+		{"scalar", []interface{}{"scalar"}},
+		{nil, nil},
+	} {
+		result, err := rpcClient.RpcRequest("echo", c.arg)
+		if err != nil || !reflect.DeepEqual(result, c.expect) {
+			t.Fatal("unexpected result", c.arg, result, err)
+		}
+	}
+
+	_, err = rpcClient.RpcRequest("fail", nil)
+	rpcErr, ok := err.(*RpcError)
+	if !ok || rpcErr.Code != -32000 || rpcErr.Message != "failed" || string(rpcErr.Data) != `{"retry":3}` {
+		t.Fatal("unexpected error object", err)
+	}
+
+	_, err = rpcClient.RpcRequest("invalid", nil)
+	rpcErr, ok = err.(*RpcError)
+	if !ok || rpcErr.Code != -32600 {
+		t.Fatal("expected the error object without id to fail the call", err)
+	}
+
+	result, err := rpcClient.RpcRequest("push", nil)
+	if err != nil || result != true {
+		t.Fatal("server notification broke the response", result, err)
+	}
+}
+
+func TestJsonRpc2Notify(t *testing.T) {
+	port, notifications, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	err = rpcClient.RpcNotify("log", []string{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-notifications:
+		if n.Version != "2.0" || n.Method != "log" || string(n.Params) != `["hello"]` {
+			t.Fatal("unexpected notification", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not received")
+	}
+
+	v1Client := new(RpcClient)
+	err = v1Client.RpcConnect("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v1Client.RpcDisConnect()
+	if v1Client.RpcNotify("log", nil) == nil {
+		t.Fatal("expected notifications to need a 2.0 client")
+	}
+}
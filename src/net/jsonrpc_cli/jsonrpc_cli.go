@@ -1,6 +1,8 @@
 package jsonrpc_cli
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net"
 	"net/rpc"
@@ -12,6 +14,20 @@ import (
 type RpcClient struct {
 	rpcConn *net.Conn
 	rpcCli  *rpc.Client
+	// set for JSON-RPC 2.0 clients
+	codec *clientCodec
+	// default timeout of calls without a context deadline, 0 waits forever
+	callTimeOut float64
+	// set for clients whose connection carries server notifications
+	subscriptions *subscriptionRegistry
+	// set for clients which reconnect on their own
+	failover     *failoverState
+	interceptors []RpcInterceptor
+}
+
+// SetCallTimeOut bounds calls whose context has no deadline to timeOut seconds, 0 disables it
+func (g *RpcClient) SetCallTimeOut(timeOut float64) {
+	g.callTimeOut = timeOut
 }
 
 func (g *RpcClient) RpcConnect(serverAddr string, serverPort uint16, timeOut float64) error {
@@ -28,10 +44,86 @@ func (g *RpcClient) RpcConnect(serverAddr string, serverPort uint16, timeOut flo
 
 	g.rpcConn = &client
 	g.rpcCli = rpcClient
+	g.codec = nil
+	g.subscriptions = nil
+	g.failover = nil
+	return nil
+}
+
+// RpcConnectV2 connects to a JSON-RPC 2.0 server speaking newline delimited JSON over TCP.
+// Method names are sent as they are, without the Service.Method convention of RpcConnect.
+func (g *RpcClient) RpcConnectV2(serverAddr string, serverPort uint16, timeOut float64) error {
+	client, err := net.DialTimeout("tcp", serverAddr+":"+strconv.Itoa(int(serverPort)),
+		time.Duration(timeOut*1000*1000*1000))
+	if err != nil {
+		return err
+	}
+
+	g.rpcConn = &client
+	g.failover = nil
+	g.codec = newClientCodec(newTcpTransport(client))
+	g.initSubscriptions()
+	g.rpcCli = rpc.NewClientWithCodec(g.codec)
+	g.subscriptions.setClient(g)
+	return nil
+}
+
+// RpcConnectHttp sends JSON-RPC 2.0 requests as HTTP POSTs to config.URL.
+// No connection is opened upfront, calls run concurrently over the http.Client.
+func (g *RpcClient) RpcConnectHttp(config HttpConfig) error {
+	if config.URL == "" {
+		return errors.New("invalid rpc url")
+	}
+	g.rpcConn = nil
+	g.codec = newClientCodec(newHttpTransport(config))
+	g.rpcCli = rpc.NewClientWithCodec(g.codec)
+	g.subscriptions = nil
+	g.failover = nil
+	return nil
+}
+
+// RpcConnectWs connects to a JSON-RPC 2.0 server over a websocket, which carries
+// concurrent calls and the notifications of RpcSubscribe.
+// With config.ReconnectInterval set the client dials again when the connection is lost,
+// the calls waiting then fail with a TransportErrorCode error object and subscriptions are made again.
+func (g *RpcClient) RpcConnectWs(config WsConfig) error {
+	if config.ReconnectInterval > 0 {
+		t, err := newReconnectTransport([]endpoint{wsEndpoint(config)}, config.ReconnectInterval,
+			config.MaxReconnectInterval)
+		if err != nil {
+			return err
+		}
+		failover := new(failoverState)
+		failover.transport = t
+		failover.idempotent = make(map[string]bool)
+		failover.maxRetries = 0
+		g.connectReconnecting(failover)
+		return nil
+	}
+
+	t, err := newWsTransport(config)
+	if err != nil {
+		return err
+	}
+	g.rpcConn = nil
+	g.failover = nil
+	g.codec = newClientCodec(t)
+	g.initSubscriptions()
+	g.rpcCli = rpc.NewClientWithCodec(g.codec)
+	g.subscriptions.setClient(g)
 	return nil
 }
 
+func (g *RpcClient) initSubscriptions() {
+	g.subscriptions = newSubscriptionRegistry()
+	g.codec.onNotification = g.subscriptions.dispatch
+	g.codec.onDisconnect = g.subscriptions.closeAll
+}
+
 func (g *RpcClient) RpcDisConnect() {
+	if g.subscriptions != nil {
+		g.subscriptions.closeAll(rpc.ErrShutdown)
+	}
 	if g.rpcCli != nil {
 		_ = g.rpcCli.Close()
 		g.rpcCli = nil
@@ -43,17 +135,12 @@ func (g *RpcClient) RpcDisConnect() {
 	}
 }
 
+// RpcRequest calls a method and returns the decoded result.
+// With RpcConnectV2, arrays and slices are sent as positional params, structs and maps as named
+// params and other values as a single positional param. Error objects are returned as *RpcError.
 func (g RpcClient) RpcRequest(rpcFuncName string, rpcArg interface{}) (interface{}, error) {
-	if g.rpcConn == nil {
-		return nil, errors.New("invalid rpc connection")
-	}
-
-	if g.rpcCli == nil {
-		return nil, errors.New("invalid rpc client")
-	}
-
 	var replyObj interface{}
-	err := g.rpcCli.Call(rpcFuncName, rpcArg, &replyObj)
+	err := g.call(rpcFuncName, rpcArg, &replyObj)
 
 	if err != nil {
 		return nil, err
@@ -62,6 +149,120 @@ func (g RpcClient) RpcRequest(rpcFuncName string, rpcArg interface{}) (interface
 	}
 }
 
+// RpcCall calls a method and decodes the result into reply, which must be a pointer.
+// A *json.RawMessage reply keeps the result undecoded.
+func (g RpcClient) RpcCall(rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	return g.call(rpcFuncName, rpcArg, reply)
+}
+
+// RpcCallRaw calls a method and returns the result as it was received
+func (g RpcClient) RpcCallRaw(rpcFuncName string, rpcArg interface{}) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := g.call(rpcFuncName, rpcArg, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Call calls a method and decodes the result into a T
+func Call[T any](g *RpcClient, rpcFuncName string, rpcArg interface{}) (T, error) {
+	var reply T
+	err := g.call(rpcFuncName, rpcArg, &reply)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return reply, nil
+}
+
+// RpcRequestContext calls a method like RpcCall, returning ctx.Err() once the context is done.
+// A call given up on is not cancelled on the server, its late response is dropped and
+// the client stays usable.
+func (g RpcClient) RpcRequestContext(ctx context.Context, rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	return g.invoke(ctx, rpcFuncName, rpcArg, reply)
+}
+
+func (g RpcClient) call(rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	return g.invoke(context.Background(), rpcFuncName, rpcArg, reply)
+}
+
+// invoke is the single path of all calls of the client
+func (g RpcClient) invoke(ctx context.Context, rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	if g.rpcCli == nil {
+		return errors.New("invalid rpc client")
+	}
+	return g.chain()(ctx, rpcFuncName, rpcArg, reply)
+}
+
+// invokeRetrying is the end of the interceptor chain
+func (g RpcClient) invokeRetrying(ctx context.Context, rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	ctx, cancel := g.callContext(ctx)
+	defer cancel()
+
+	for retries := 0; ; retries++ {
+		err := g.invokeOnce(ctx, rpcFuncName, rpcArg, reply)
+		if !g.failover.shouldRetry(rpcFuncName, err, retries) {
+			return err
+		}
+		// idempotent calls lost with the connection are sent again once reconnected
+		waitErr := g.failover.transport.waitConnected(ctx)
+		if waitErr != nil {
+			return err
+		}
+	}
+}
+
+func (g RpcClient) invokeOnce(ctx context.Context, rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	// the reply is decoded here, as net/rpc shuts the client down on body decode errors
+	// and a call given up on must not write into the caller's reply later
+	var raw json.RawMessage
+	var arg interface{} = rpcArg
+	if g.codec != nil {
+		arg = &callArg{ctx: ctx, arg: rpcArg}
+	}
+	call := g.rpcCli.Go(rpcFuncName, arg, &raw, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if call.Error != nil {
+		if g.codec == nil {
+			// the errors of a JSON-RPC 1.0 client stay plain rpc.ServerError strings
+			return call.Error
+		}
+		return toRpcError(call.Error)
+	}
+	return decodeReply(raw, reply)
+}
+
+func (g RpcClient) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	_, hasDeadline := ctx.Deadline()
+	if g.callTimeOut > 0 && !hasDeadline {
+		return context.WithTimeout(ctx, time.Duration(g.callTimeOut*1000*1000*1000))
+	}
+	return context.WithCancel(ctx)
+}
+
+func decodeReply(raw json.RawMessage, reply interface{}) error {
+	if reply == nil {
+		return nil
+	}
+	if len(raw) == 0 {
+		raw = json.RawMessage("null")
+	}
+	return json.Unmarshal(raw, reply)
+}
+
+// RpcNotify sends a JSON-RPC 2.0 notification, which gets no response
+func (g RpcClient) RpcNotify(rpcFuncName string, rpcArg interface{}) error {
+	if g.codec == nil {
+		return errors.New("notifications need a JSON-RPC 2.0 client")
+	}
+	return g.codec.notify(rpcFuncName, rpcArg, nil)
+}
+
 func RpcRequestSimple(serverAddr string, serverPort uint16, timeOut float64,
 	rpcFuncName string, rpcArg interface{}) (interface{}, error) {
 	rpcClient := new(RpcClient)
@@ -73,3 +274,14 @@ func RpcRequestSimple(serverAddr string, serverPort uint16, timeOut float64,
 	defer rpcClient.RpcDisConnect()
 	return rpcClient.RpcRequest(rpcFuncName, rpcArg)
 }
+
+func RpcRequestSimpleHttp(config HttpConfig, rpcFuncName string, rpcArg interface{}) (interface{}, error) {
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectHttp(config)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rpcClient.RpcDisConnect()
+	return rpcClient.RpcRequest(rpcFuncName, rpcArg)
+}
@@ -1,8 +1,14 @@
 package jsonrpc_cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
 	"testing"
+	"time"
 )
 
 func TestAll(t *testing.T) {
@@ -27,3 +33,134 @@ func TestAll(t *testing.T) {
 	}
 	rpcClient.RpcDisConnect()
 }
+
+type v1Service struct{}
+
+func (v1Service) Fail(args *int, reply *int) error {
+	return errors.New("boom")
+}
+
+func TestRpcErrorV1(t *testing.T) {
+	server := rpc.NewServer()
+	_ = server.RegisterName("Test", v1Service{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+
+	rpcClient := new(RpcClient)
+	err = rpcClient.RpcConnect("127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+	err = rpcClient.RpcCall("Test.Fail", 1, nil)
+	if err != rpc.ServerError("boom") {
+		t.Fatal("expected the plain error of a 1.0 server", err)
+	}
+}
+
+type echoPoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func TestRpcCallTyped(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	var point echoPoint
+	err = rpcClient.RpcCall("echo", echoPoint{X: 1, Y: 2}, &point)
+	if err != nil || point != (echoPoint{X: 1, Y: 2}) {
+		t.Fatal("unexpected reply", point, err)
+	}
+	points, err := Call[[]int](rpcClient, "echo", []int{3, 4})
+	if err != nil || len(points) != 2 || points[1] != 4 {
+		t.Fatal("unexpected reply", points, err)
+	}
+	raw, err := rpcClient.RpcCallRaw("echo", map[string]string{"k": "v"})
+	if err != nil || string(raw) != `{"k":"v"}` {
+		t.Fatal("unexpected raw reply", string(raw), err)
+	}
+	_, err = Call[int](rpcClient, "echo", "not a number")
+	if err == nil {
+		t.Fatal("expected a decode error")
+	}
+	_, err = Call[int](rpcClient, "fail", nil)
+	if _, ok := err.(*RpcError); !ok {
+		t.Fatal("expected an error object", err)
+	}
+	err = rpcClient.RpcCall("echo", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var typed []string
+	calls := []*RpcBatchCall{{Method: "echo", Arg: []string{"a", "b"}, Reply: &typed}, {Method: "echo", Arg: 1}}
+	err = rpcClient.RpcBatch(calls)
+	if err != nil || len(typed) != 2 || typed[1] != "b" || calls[0].Result != nil || calls[1].Result == nil {
+		t.Fatal("unexpected batch replies", typed, calls[1].Result, err)
+	}
+}
+
+func TestRpcRequestContext(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	reply := "untouched"
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = rpcClient.RpcRequestContext(ctx, "slow", nil, &reply)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 150*time.Millisecond {
+		t.Fatal("expected the deadline to end the call", err, time.Since(start))
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	err = rpcClient.RpcRequestContext(cancelled, "echo", nil, &reply)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected cancellation", err)
+	}
+
+	var echoed []string
+	err = rpcClient.RpcRequestContext(context.Background(), "echo", []string{"after"}, &echoed)
+	if err != nil || len(echoed) != 1 || echoed[0] != "after" {
+		t.Fatal("client unusable after a timed out call", echoed, err)
+	}
+	if reply != "untouched" {
+		t.Fatal("late response written into the reply", reply)
+	}
+
+	rpcClient.SetCallTimeOut(0.05)
+	_, err = rpcClient.RpcRequest("slow", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the default call timeout", err)
+	}
+	calls := []*RpcBatchCall{{Method: "slow"}, {Method: "echo", Arg: 1}}
+	err = rpcClient.RpcBatch(calls)
+	if err != nil || !errors.Is(calls[0].Error, context.DeadlineExceeded) {
+		t.Fatal("expected the batch to time out", err, calls[0].Error)
+	}
+}
@@ -0,0 +1,256 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// endpoint dials a transport carrying a single connection to address
+type endpoint struct {
+	address string
+	dial    func() (transport, error)
+}
+
+type transportMessage struct {
+	message []byte
+	err     error
+}
+
+// reconnectTransport reads the connection of one of the endpoints in its own goroutine.
+// A lost connection is reported as errConnectionLost and the other endpoints are dialed in turn,
+// with a delay growing from reconnectInterval to maxReconnectInterval between failed rounds.
+// Writes which fail meanwhile are answered with transport errors.
+type reconnectTransport struct {
+	endpoints            []endpoint
+	reconnectInterval    float64
+	maxReconnectInterval float64
+	mutex                *sync.Mutex
+	current              transport
+	active               int
+	// closed while connected, replaced when the connection is lost
+	connected   chan struct{}
+	messages    chan transportMessage
+	done        chan struct{}
+	closeOnce   *sync.Once
+	onReconnect func()
+}
+
+// newReconnectTransport connects to the first endpoint which can be dialed
+func newReconnectTransport(endpoints []endpoint, reconnectInterval float64, maxReconnectInterval float64) (*reconnectTransport, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoint")
+	}
+	t := new(reconnectTransport)
+	t.endpoints = endpoints
+	t.reconnectInterval = reconnectInterval
+	t.maxReconnectInterval = maxReconnectInterval
+	t.mutex = new(sync.Mutex)
+	t.current = nil
+	t.active = 0
+	t.connected = make(chan struct{})
+	t.messages = make(chan transportMessage, 16)
+	t.done = make(chan struct{})
+	t.closeOnce = new(sync.Once)
+	t.onReconnect = nil
+
+	var err error
+	for i := range endpoints {
+		t.current, err = endpoints[i].dial()
+		if err == nil {
+			t.active = i
+			close(t.connected)
+			return t, nil
+		}
+	}
+	return nil, err
+}
+
+// start reads the connection, onReconnect must be set before
+func (t *reconnectTransport) start() {
+	go t.run()
+}
+
+func (t *reconnectTransport) isClosed() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *reconnectTransport) run() {
+	for {
+		t.mutex.Lock()
+		current := t.current
+		t.mutex.Unlock()
+		if current == nil {
+			return
+		}
+		t.receive(current)
+		if t.isClosed() {
+			return
+		}
+
+		t.mutex.Lock()
+		if t.current == current {
+			t.current = nil
+		}
+		t.connected = make(chan struct{})
+		t.mutex.Unlock()
+		_ = current.close()
+
+		if !t.push(transportMessage{err: errConnectionLost}) || !t.reconnect() {
+			return
+		}
+		if t.onReconnect != nil {
+			go t.onReconnect()
+		}
+	}
+}
+
+func (t *reconnectTransport) receive(current transport) {
+	for {
+		message, err := current.readMessage()
+		if err != nil {
+			return
+		}
+		if !t.push(transportMessage{message: message}) {
+			return
+		}
+	}
+}
+
+func (t *reconnectTransport) push(message transportMessage) bool {
+	select {
+	case t.messages <- message:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *reconnectTransport) wait(interval float64) bool {
+	select {
+	case <-time.After(time.Duration(interval * 1000 * 1000 * 1000)):
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// reconnect tries the endpoints starting after the lost one, so a failing endpoint is tried last
+func (t *reconnectTransport) reconnect() bool {
+	interval := t.reconnectInterval
+	next := t.active + 1
+	for {
+		if !t.wait(interval) {
+			return false
+		}
+		for i := 0; i < len(t.endpoints); i++ {
+			index := (next + i) % len(t.endpoints)
+			current, err := t.endpoints[index].dial()
+			if err != nil {
+				continue
+			}
+			t.mutex.Lock()
+			if t.isClosed() {
+				t.mutex.Unlock()
+				_ = current.close()
+				return false
+			}
+			t.current = current
+			t.active = index
+			close(t.connected)
+			t.mutex.Unlock()
+			return true
+		}
+		interval = interval * 2
+		if interval > t.maxReconnectInterval {
+			interval = t.maxReconnectInterval
+		}
+		if interval < t.reconnectInterval {
+			interval = t.reconnectInterval
+		}
+	}
+}
+
+// waitConnected returns once a connection is up
+func (t *reconnectTransport) waitConnected(ctx context.Context) error {
+	t.mutex.Lock()
+	connected := t.connected
+	t.mutex.Unlock()
+	select {
+	case <-connected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return rpc.ErrShutdown
+	}
+}
+
+// address is the endpoint connected to, empty while reconnecting
+func (t *reconnectTransport) address() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.current == nil {
+		return ""
+	}
+	return t.endpoints[t.active].address
+}
+
+func (t *reconnectTransport) writeMessage(message []byte) error {
+	if t.isClosed() {
+		return rpc.ErrShutdown
+	}
+	t.mutex.Lock()
+	current := t.current
+	t.mutex.Unlock()
+
+	var err error
+	if current == nil {
+		err = errors.New("not connected")
+	} else {
+		err = current.writeMessage(message)
+		if err != nil {
+			// the reader notices the broken connection
+			_ = current.close()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	response := transportErrorResponse(message, err)
+	if response != nil {
+		go t.push(transportMessage{message: response})
+	}
+	return nil
+}
+
+func (t *reconnectTransport) readMessage() ([]byte, error) {
+	select {
+	case message := <-t.messages:
+		return message.message, message.err
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *reconnectTransport) close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.mutex.Lock()
+		current := t.current
+		t.current = nil
+		t.mutex.Unlock()
+		if current != nil {
+			_ = current.close()
+		}
+	})
+	return nil
+}
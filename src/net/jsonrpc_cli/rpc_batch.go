@@ -0,0 +1,85 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/rpc"
+)
+
+// RpcBatchCall is one call of RpcBatch, Result and Error are set when RpcBatch returns
+type RpcBatchCall struct {
+	Method string
+	Arg    interface{}
+	// sent as a notification, which gets neither result nor error
+	Notify bool
+	// pointer the result is decoded into, nil decodes into Result
+	Reply  interface{}
+	Result interface{}
+	Error  error
+}
+
+// RpcBatch sends the calls as one JSON-RPC 2.0 batch and waits for all responses,
+// which are matched to the calls by id whatever order the server answers in.
+// The returned error tells the batch could not be sent, its calls then fail with it and the client
+// stays usable. Errors of single calls are in their Error.
+func (g RpcClient) RpcBatch(calls []*RpcBatchCall) error {
+	return g.RpcBatchContext(context.Background(), calls)
+}
+
+// RpcBatchContext is RpcBatch giving up on the calls not answered when the context is done,
+// their Error is set to ctx.Err()
+func (g RpcClient) RpcBatchContext(ctx context.Context, calls []*RpcBatchCall) error {
+	if g.rpcCli == nil {
+		return errors.New("invalid rpc client")
+	}
+	if g.codec == nil {
+		return errors.New("batch requests need a JSON-RPC 2.0 client")
+	}
+
+	ctx, cancel := g.callContext(ctx)
+	defer cancel()
+
+	// the calls carry the batch to WriteRequest, concurrent calls of the client are not collected
+	pending := make([]*rpc.Call, len(calls))
+	batch := new(pendingBatch)
+	for i, call := range calls {
+		call.Result = nil
+		call.Error = nil
+		if call.Notify {
+			call.Error = g.codec.notify(call.Method, call.Arg, batch)
+			continue
+		}
+		arg := &callArg{ctx: ctx, arg: call.Arg, batch: batch}
+		pending[i] = g.rpcCli.Go(call.Method, arg, new(json.RawMessage), make(chan *rpc.Call, 1))
+	}
+	err := g.codec.endBatch(ctx, batch)
+
+	for i, call := range calls {
+		if pending[i] == nil {
+			continue
+		}
+		if err != nil {
+			// only the calls of the batch fail, the client stays usable
+			call.Error = err
+			continue
+		}
+		select {
+		case <-pending[i].Done:
+		case <-ctx.Done():
+			call.Error = ctx.Err()
+			continue
+		}
+		call.Error = toRpcError(pending[i].Error)
+		if call.Error != nil {
+			continue
+		}
+		raw := *pending[i].Reply.(*json.RawMessage)
+		if call.Reply != nil {
+			call.Error = decodeReply(raw, call.Reply)
+		} else {
+			call.Error = decodeReply(raw, &call.Result)
+		}
+	}
+	return err
+}
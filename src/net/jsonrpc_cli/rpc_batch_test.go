@@ -0,0 +1,119 @@
+package jsonrpc_cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRpcBatch(t *testing.T) {
+	port, notifications, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	calls := make([]*RpcBatchCall, 0)
+	for i := 0; i < 20; i++ {
+		calls = append(calls, &RpcBatchCall{Method: "echo", Arg: []int{i}})
+	}
+	calls = append(calls, &RpcBatchCall{Method: "fail"}, &RpcBatchCall{Method: "log", Arg: "batched", Notify: true})
+	err = rpcClient.RpcBatch(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if calls[i].Error != nil || !reflect.DeepEqual(calls[i].Result, []interface{}{float64(i)}) {
+			t.Fatal("unexpected result", i, calls[i].Result, calls[i].Error)
+		}
+	}
+	rpcErr, ok := calls[20].Error.(*RpcError)
+	if !ok || rpcErr.Code != -32000 || calls[20].Result != nil {
+		t.Fatal("expected an error object", calls[20].Error)
+	}
+	if calls[21].Error != nil || calls[21].Result != nil {
+		t.Fatal("unexpected notification result", calls[21])
+	}
+	select {
+	case n := <-notifications:
+		if n.Method != "log" {
+			t.Fatal("unexpected notification", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not received")
+	}
+
+	// single calls still work between batches
+	result, err := rpcClient.RpcRequest("echo", "single")
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"single"}) {
+		t.Fatal("unexpected result", result, err)
+	}
+	err = rpcClient.RpcBatch([]*RpcBatchCall{{Method: "log", Notify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-notifications
+}
+
+// batchFailTransport answers single requests with "ok" and fails to write batches
+type batchFailTransport struct {
+	responses chan []byte
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func (t *batchFailTransport) writeMessage(message []byte) error {
+	if message[0] == '[' {
+		return errors.New("batch rejected")
+	}
+	var request testRequest
+	_ = json.Unmarshal(message, &request)
+	response, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": "ok"})
+	t.responses <- response
+	return nil
+}
+
+func (t *batchFailTransport) readMessage() ([]byte, error) {
+	select {
+	case response := <-t.responses:
+		return response, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *batchFailTransport) close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+	return nil
+}
+
+func TestRpcBatchWriteError(t *testing.T) {
+	transport := &batchFailTransport{responses: make(chan []byte, 16), done: make(chan struct{}), closeOnce: new(sync.Once)}
+	rpcClient := new(RpcClient)
+	rpcClient.codec = newClientCodec(transport)
+	rpcClient.rpcCli = rpc.NewClientWithCodec(rpcClient.codec)
+	defer rpcClient.RpcDisConnect()
+
+	calls := []*RpcBatchCall{{Method: "a"}, {Method: "b"}}
+	err := rpcClient.RpcBatch(calls)
+	if err == nil || calls[0].Error != err || calls[1].Error != err {
+		t.Fatal("expected the calls of the batch to fail", err, calls[0].Error, calls[1].Error)
+	}
+	for i := 0; i < 2; i++ {
+		result, err := rpcClient.RpcRequest("single", nil)
+		if err != nil || result != "ok" {
+			t.Fatal("expected the client to stay usable", result, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/net/metrics"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RpcInvoker sends a call and decodes its result into reply
+type RpcInvoker func(ctx context.Context, method string, params interface{}, reply interface{}) error
+
+// RpcInterceptor wraps the calls of a client, it may change the call, skip it or call next any number of times
+type RpcInterceptor func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error
+
+// Use appends interceptors to the chain of the client, the first one added sees the calls first.
+// All calls pass the chain, batches and notifications do not.
+// The default call timeout applies to every call next makes.
+func (g *RpcClient) Use(interceptors ...RpcInterceptor) {
+	chain := make([]RpcInterceptor, 0, len(g.interceptors)+len(interceptors))
+	chain = append(chain, g.interceptors...)
+	g.interceptors = append(chain, interceptors...)
+}
+
+func (g RpcClient) chain() RpcInvoker {
+	next := g.invokeRetrying
+	for i := len(g.interceptors) - 1; i >= 0; i-- {
+		interceptor := g.interceptors[i]
+		inner := next
+		next = func(ctx context.Context, method string, params interface{}, reply interface{}) error {
+			return interceptor(ctx, method, params, reply, inner)
+		}
+	}
+	return next
+}
+
+// LoggingInterceptor logs every call with its latency and error, nil uses the standard logger
+func LoggingInterceptor(logger *log.Logger) RpcInterceptor {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		start := time.Now()
+		err := next(ctx, method, params, reply)
+		if err != nil {
+			logger.Printf("rpc %s failed after %v: %v", method, time.Since(start), err)
+		} else {
+			logger.Printf("rpc %s took %v", method, time.Since(start))
+		}
+		return err
+	}
+}
+
+type RetryConfig struct {
+	MaxRetries int
+	// seconds before the first retry, doubled per retry up to MaxInterval, 0 keeps Interval
+	Interval    float64
+	MaxInterval float64
+	// tells a failed call may be sent again, nil retries the calls of IdempotentMethods failed
+	// with TransportErrorCode, a call may have reached the server before its transport failed
+	Retryable func(method string, err error) bool
+	// methods safe to call twice, used when Retryable is nil
+	IdempotentMethods []string
+}
+
+func idempotentTransportError(methods []string) func(method string, err error) bool {
+	idempotent := make(map[string]bool)
+	for _, method := range methods {
+		idempotent[method] = true
+	}
+	return func(method string, err error) bool {
+		rpcErr, ok := err.(*RpcError)
+		return ok && rpcErr.Code == TransportErrorCode && idempotent[method]
+	}
+}
+
+// RetryInterceptor calls again the calls config.Retryable accepts, waiting a growing interval in between.
+// It returns ctx.Err() when the context is done while waiting.
+func RetryInterceptor(config RetryConfig) RpcInterceptor {
+	retryable := config.Retryable
+	if retryable == nil {
+		retryable = idempotentTransportError(config.IdempotentMethods)
+	}
+	return func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		interval := config.Interval
+		for retries := 0; ; retries++ {
+			err := next(ctx, method, params, reply)
+			if err == nil || retries >= config.MaxRetries || !retryable(method, err) {
+				return err
+			}
+			timer := time.NewTimer(time.Duration(interval * 1000 * 1000 * 1000))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			interval = interval * 2
+			if interval > config.MaxInterval {
+				interval = config.MaxInterval
+			}
+			if interval < config.Interval {
+				interval = config.Interval
+			}
+		}
+	}
+}
+
+// DefaultLatencyBuckets are the upper bounds in seconds of LatencyHistogram buckets
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type methodLatency struct {
+	// per bucket, the last one counts the calls above all bounds
+	counts []uint64
+	sum    time.Duration
+	count  uint64
+	errors uint64
+}
+
+// LatencyStats are the observations of a method, Buckets are cumulative like Prometheus buckets
+type LatencyStats struct {
+	Count   uint64
+	Errors  uint64
+	Sum     time.Duration
+	Buckets []uint64
+}
+
+// LatencyHistogram counts call latencies per method, Interceptor feeds it and
+// WriteTo renders it in the Prometheus text exposition format
+type LatencyHistogram struct {
+	mutex   *sync.Mutex
+	prefix  string
+	buckets []float64
+	methods map[string]*methodLatency
+}
+
+// Initialise sets the metric name prefix and the bucket bounds in seconds, nil uses DefaultLatencyBuckets
+func (h *LatencyHistogram) Initialise(prefix string, buckets []float64) {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	h.mutex = new(sync.Mutex)
+	h.prefix = prefix
+	h.buckets = append([]float64{}, buckets...)
+	sort.Float64s(h.buckets)
+	h.methods = make(map[string]*methodLatency)
+}
+
+func (h *LatencyHistogram) Observe(method string, latency time.Duration, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	m, ok := h.methods[method]
+	if !ok {
+		m = &methodLatency{counts: make([]uint64, len(h.buckets)+1)}
+		h.methods[method] = m
+	}
+	index := sort.SearchFloat64s(h.buckets, latency.Seconds())
+	m.counts[index]++
+	m.sum = m.sum + latency
+	m.count++
+	if err != nil {
+		m.errors++
+	}
+}
+
+func (h *LatencyHistogram) Interceptor() RpcInterceptor {
+	return func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		start := time.Now()
+		err := next(ctx, method, params, reply)
+		h.Observe(method, time.Since(start), err)
+		return err
+	}
+}
+
+func (h *LatencyHistogram) Stats(method string) LatencyStats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	m, ok := h.methods[method]
+	if !ok {
+		return LatencyStats{Buckets: make([]uint64, len(h.buckets)+1)}
+	}
+	stats := LatencyStats{Count: m.count, Errors: m.errors, Sum: m.sum, Buckets: make([]uint64, len(m.counts))}
+	cumulative := uint64(0)
+	for i, count := range m.counts {
+		cumulative = cumulative + count
+		stats.Buckets[i] = cumulative
+	}
+	return stats
+}
+
+func (h *LatencyHistogram) WriteTo(writer io.Writer) (int64, error) {
+	h.mutex.Lock()
+	methods := make([]string, 0, len(h.methods))
+	for method := range h.methods {
+		methods = append(methods, method)
+	}
+	h.mutex.Unlock()
+	sort.Strings(methods)
+
+	lines := make([]string, 0)
+	name := h.prefix + "_request_duration_seconds"
+	lines = append(lines, "# HELP "+name+" Latency of calls.", "# TYPE "+name+" histogram")
+	errorLines := make([]string, 0, len(methods))
+	for _, method := range methods {
+		stats := h.Stats(method)
+		label := metrics.EscapeLabelValue(method)
+		for i, bound := range h.buckets {
+			lines = append(lines, fmt.Sprintf("%s_bucket{method=\"%s\",le=\"%s\"} %d", name, label,
+				strconv.FormatFloat(bound, 'g', -1, 64), stats.Buckets[i]))
+		}
+		lines = append(lines, fmt.Sprintf("%s_bucket{method=\"%s\",le=\"+Inf\"} %d", name, label, stats.Count))
+		lines = append(lines, fmt.Sprintf("%s_sum{method=\"%s\"} %v", name, label, stats.Sum.Seconds()))
+		lines = append(lines, fmt.Sprintf("%s_count{method=\"%s\"} %d", name, label, stats.Count))
+		errorLines = append(errorLines, fmt.Sprintf("%s_request_errors_total{method=\"%s\"} %d", h.prefix, label, stats.Errors))
+	}
+	if len(methods) == 0 {
+		return 0, nil
+	}
+	lines = append(lines, "# HELP "+h.prefix+"_request_errors_total Calls which returned an error.",
+		"# TYPE "+h.prefix+"_request_errors_total counter")
+	lines = append(lines, errorLines...)
+
+	n, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	return int64(n), err
+}
+
+func (h *LatencyHistogram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = h.WriteTo(w)
+}
@@ -0,0 +1,144 @@
+package jsonrpc_cli
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	order := make([]string, 0)
+	rpcClient.Use(func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		order = append(order, "outer")
+		return next(ctx, method, params, reply)
+	}, func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		order = append(order, "inner")
+		// interceptors may rewrite the call
+		return next(ctx, method, []string{"token", params.(string)}, reply)
+	})
+	result, err := rpcClient.RpcRequest("echo", "x")
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"token", "x"}) {
+		t.Fatal("unexpected result", result, err)
+	}
+	if !reflect.DeepEqual(order, []string{"outer", "inner"}) {
+		t.Fatal("unexpected order", order)
+	}
+}
+
+func TestBuiltinInterceptors(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	logs := new(bytes.Buffer)
+	histogram := new(LatencyHistogram)
+	histogram.Initialise("rpc", []float64{0.1, 0.01})
+	attempts := 0
+	rpcClient.Use(LoggingInterceptor(log.New(logs, "", 0)), histogram.Interceptor(),
+		RetryInterceptor(RetryConfig{MaxRetries: 2, Interval: 0.01, MaxInterval: 0.02,
+			Retryable: func(method string, err error) bool {
+				rpcErr, ok := err.(*RpcError)
+				return ok && rpcErr.Code == -32000
+			}}),
+		func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+			attempts++
+			return next(ctx, method, params, reply)
+		})
+
+	for i := 0; i < 3; i++ {
+		_, err = rpcClient.RpcRequest("echo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = rpcClient.RpcRequest("fail", nil)
+	if err == nil || attempts != 6 {
+		t.Fatal("expected the failing call to be tried 3 times", attempts, err)
+	}
+	_, err = rpcClient.RpcRequest("slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logs.String(), "rpc echo took") || !strings.Contains(logs.String(), "rpc fail failed after") {
+		t.Fatal("unexpected logs", logs.String())
+	}
+	stats := histogram.Stats("echo")
+	if stats.Count != 3 || stats.Errors != 0 || stats.Buckets[2] != 3 {
+		t.Fatal("unexpected echo stats", stats)
+	}
+	stats = histogram.Stats("slow")
+	if stats.Count != 1 || stats.Buckets[1] != 0 || stats.Sum < 200*time.Millisecond {
+		t.Fatal("unexpected slow stats", stats)
+	}
+	if histogram.Stats("fail").Errors != 1 {
+		t.Fatal("expected the failed call to be counted once")
+	}
+	exposition := new(bytes.Buffer)
+	_, _ = histogram.WriteTo(exposition)
+	for _, line := range []string{
+		"# TYPE rpc_request_duration_seconds histogram",
+		`rpc_request_duration_seconds_bucket{method="echo",le="+Inf"} 3`,
+		`rpc_request_duration_seconds_bucket{method="slow",le="0.1"} 0`,
+		`rpc_request_errors_total{method="fail"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), line+"\n") {
+			t.Fatal("missing line", line, exposition.String())
+		}
+	}
+
+	// the context bounds the retries
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	err = rpcClient.RpcRequestContext(ctx, "fail", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected the deadline to stop the retries", err)
+	}
+}
+
+func TestRetryIdempotentOnly(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	attempts := make(map[string]int)
+	rpcClient.Use(RetryInterceptor(RetryConfig{MaxRetries: 2, IdempotentMethods: []string{"get"}}),
+		func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+			attempts[method]++
+			return &RpcError{Code: TransportErrorCode, Message: "connection lost"}
+		})
+	_, err = rpcClient.RpcRequest("get", nil)
+	if err == nil || attempts["get"] != 3 {
+		t.Fatal("expected the idempotent call to be retried", attempts, err)
+	}
+	_, err = rpcClient.RpcRequest("transfer", nil)
+	if err == nil || attempts["transfer"] != 1 {
+		t.Fatal("expected other calls not to be retried", attempts, err)
+	}
+}
@@ -0,0 +1,352 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultPoolMaxConns = 2
+
+type RpcPoolConfig struct {
+	// connections per endpoint, every connection carries concurrent calls, 0 means DefaultPoolMaxConns
+	MaxConns int
+	// calls in flight per endpoint, further calls wait for one to finish, 0 means unlimited
+	MaxInFlight int
+	// connections without calls for this long are closed, 0 keeps them
+	IdleTimeout time.Duration
+	// timeout of connecting in seconds, a shorter deadline of the call's context applies too
+	TimeOut float64
+	// connect with RpcConnectV2 instead of RpcConnect
+	JsonRpc2 bool
+}
+
+type RpcPoolStats struct {
+	OpenCount         int
+	InFlight          int
+	DialCount         uint64
+	WaitCount         uint64
+	WaitDuration      time.Duration
+	ClosedBroken      uint64
+	ClosedIdleTimeout uint64
+}
+
+type pooledConn struct {
+	client   *RpcClient
+	inFlight int
+	lastUsed time.Time
+}
+
+type poolEndpoint struct {
+	serverAddr string
+	serverPort uint16
+	// one token per call in flight, nil when unlimited
+	slots   chan struct{}
+	conns   []*pooledConn
+	dialing int
+}
+
+// RpcPool shares connections to any number of endpoints between goroutines.
+// Calls go to the least busy connection of their endpoint, new connections are
+// opened while all are busy and fewer than MaxConns are open.
+type RpcPool struct {
+	mutex     *sync.Mutex
+	config    RpcPoolConfig
+	endpoints map[string]*poolEndpoint
+	closed    bool
+	stats     RpcPoolStats
+	quit      chan struct{}
+}
+
+func (p *RpcPool) Initialise(config RpcPoolConfig) {
+	if config.MaxConns == 0 {
+		config.MaxConns = DefaultPoolMaxConns
+	}
+	p.mutex = new(sync.Mutex)
+	p.config = config
+	p.endpoints = make(map[string]*poolEndpoint)
+	p.closed = false
+	p.stats = RpcPoolStats{}
+	p.quit = make(chan struct{})
+	if config.IdleTimeout > 0 {
+		go p.evictLoop(p.quit)
+	}
+}
+
+func (p *RpcPool) evictLoop(quit chan struct{}) {
+	ticker := time.NewTicker(p.config.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			p.EvictIdle()
+		}
+	}
+}
+
+// EvictIdle closes the connections without calls which exceeded IdleTimeout
+func (p *RpcPool) EvictIdle() {
+	if p.config.IdleTimeout <= 0 {
+		return
+	}
+	expired := make([]*pooledConn, 0)
+	p.mutex.Lock()
+	for _, ep := range p.endpoints {
+		kept := ep.conns[:0]
+		for _, pc := range ep.conns {
+			if pc.inFlight == 0 && time.Since(pc.lastUsed) > p.config.IdleTimeout {
+				expired = append(expired, pc)
+				p.stats.ClosedIdleTimeout++
+			} else {
+				kept = append(kept, pc)
+			}
+		}
+		ep.conns = kept
+	}
+	p.mutex.Unlock()
+
+	for _, pc := range expired {
+		pc.client.RpcDisConnect()
+	}
+}
+
+func (p *RpcPool) endpoint(serverAddr string, serverPort uint16) *poolEndpoint {
+	key := serverAddr + ":" + strconv.Itoa(int(serverPort))
+	ep, ok := p.endpoints[key]
+	if !ok {
+		ep = &poolEndpoint{serverAddr: serverAddr, serverPort: serverPort, conns: make([]*pooledConn, 0)}
+		if p.config.MaxInFlight > 0 {
+			ep.slots = make(chan struct{}, p.config.MaxInFlight)
+		}
+		p.endpoints[key] = ep
+	}
+	return ep
+}
+
+func (ep *poolEndpoint) leastBusy() *pooledConn {
+	var least *pooledConn
+	for _, pc := range ep.conns {
+		if least == nil || pc.inFlight < least.inFlight {
+			least = pc
+		}
+	}
+	return least
+}
+
+func (p *RpcPool) acquireSlot(ctx context.Context, ep *poolEndpoint) error {
+	if ep.slots == nil {
+		return nil
+	}
+	select {
+	case ep.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	start := time.Now()
+	select {
+	case ep.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.mutex.Lock()
+	p.stats.WaitCount++
+	p.stats.WaitDuration = p.stats.WaitDuration + time.Since(start)
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *RpcPool) releaseSlot(ep *poolEndpoint) {
+	if ep.slots != nil {
+		<-ep.slots
+	}
+}
+
+// acquire returns the least busy connection, or dials one when all are busy and there is room.
+// When that dial fails the call goes to the least busy connection.
+func (p *RpcPool) acquire(ctx context.Context, ep *poolEndpoint) (*pooledConn, error) {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, errors.New("rpc pool: closed")
+	}
+	least := ep.leastBusy()
+	if least != nil && (least.inFlight == 0 || len(ep.conns)+ep.dialing >= p.config.MaxConns) {
+		least.inFlight++
+		p.mutex.Unlock()
+		return least, nil
+	}
+	ep.dialing++
+	p.mutex.Unlock()
+
+	timeOut := p.config.TimeOut
+	deadline, ok := ctx.Deadline()
+	if ok && (timeOut <= 0 || time.Until(deadline).Seconds() < timeOut) {
+		timeOut = time.Until(deadline).Seconds()
+	}
+	client := new(RpcClient)
+	err := ctx.Err()
+	if err == nil && p.config.JsonRpc2 {
+		err = client.RpcConnectV2(ep.serverAddr, ep.serverPort, timeOut)
+	} else if err == nil {
+		err = client.RpcConnect(ep.serverAddr, ep.serverPort, timeOut)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ep.dialing--
+	if err != nil {
+		// a busy connection still carries the call
+		least = ep.leastBusy()
+		if least != nil && !p.closed {
+			least.inFlight++
+			return least, nil
+		}
+		return nil, err
+	}
+	p.stats.DialCount++
+	if p.closed {
+		client.RpcDisConnect()
+		return nil, errors.New("rpc pool: closed")
+	}
+	pc := &pooledConn{client: client, inFlight: 1, lastUsed: time.Now()}
+	ep.conns = append(ep.conns, pc)
+	return pc, nil
+}
+
+// isConnError tells the connection of a call is unusable, unlike errors returned by the server
+func isConnError(err error) bool {
+	// context.DeadlineExceeded is a net.Error too
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return false
+	}
+	if err == rpc.ErrShutdown || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (p *RpcPool) release(ep *poolEndpoint, pc *pooledConn, err error) {
+	p.mutex.Lock()
+	pc.inFlight--
+	pc.lastUsed = time.Now()
+	broken := false
+	if isConnError(err) {
+		for i, c := range ep.conns {
+			if c == pc {
+				ep.conns = append(ep.conns[:i], ep.conns[i+1:]...)
+				p.stats.ClosedBroken++
+				broken = true
+				break
+			}
+		}
+	}
+	p.mutex.Unlock()
+	if broken {
+		pc.client.RpcDisConnect()
+	}
+}
+
+// RpcCall calls a method on the endpoint and decodes the result into reply like RpcClient.RpcCall.
+// A call which found its connection shut down, e.g. closed by the server while idle, is sent
+// once more on a new connection.
+func (p *RpcPool) RpcCall(ctx context.Context, serverAddr string, serverPort uint16,
+	rpcFuncName string, rpcArg interface{}, reply interface{}) error {
+	p.mutex.Lock()
+	ep := p.endpoint(serverAddr, serverPort)
+	p.mutex.Unlock()
+
+	err := p.acquireSlot(ctx, ep)
+	if err != nil {
+		return err
+	}
+	defer p.releaseSlot(ep)
+
+	for attempt := 0; ; attempt++ {
+		pc, err := p.acquire(ctx, ep)
+		if err != nil {
+			return err
+		}
+		err = pc.client.RpcRequestContext(ctx, rpcFuncName, rpcArg, reply)
+		p.release(ep, pc, err)
+		// net/rpc fails calls with ErrShutdown before sending them
+		if err != rpc.ErrShutdown || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// RpcRequest calls a method on the endpoint and returns the decoded result
+func (p *RpcPool) RpcRequest(ctx context.Context, serverAddr string, serverPort uint16,
+	rpcFuncName string, rpcArg interface{}) (interface{}, error) {
+	var replyObj interface{}
+	err := p.RpcCall(ctx, serverAddr, serverPort, rpcFuncName, rpcArg, &replyObj)
+	if err != nil {
+		return nil, err
+	}
+	return replyObj, nil
+}
+
+func (p *RpcPool) Stats() RpcPoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := p.stats
+	for _, ep := range p.endpoints {
+		stats.OpenCount = stats.OpenCount + len(ep.conns)
+		for _, pc := range ep.conns {
+			stats.InFlight = stats.InFlight + pc.inFlight
+		}
+	}
+	return stats
+}
+
+// Close disconnects all connections, calls in flight fail
+func (p *RpcPool) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.quit)
+	conns := make([]*pooledConn, 0)
+	for _, ep := range p.endpoints {
+		conns = append(conns, ep.conns...)
+		ep.conns = nil
+	}
+	p.mutex.Unlock()
+
+	for _, pc := range conns {
+		pc.client.RpcDisConnect()
+	}
+}
+
+var defaultPool *RpcPool
+var defaultPoolOnce = new(sync.Once)
+
+// RpcRequestPooled is RpcRequestSimple over connections kept in a shared pool,
+// timeOut bounds connecting and the call
+func RpcRequestPooled(serverAddr string, serverPort uint16, timeOut float64,
+	rpcFuncName string, rpcArg interface{}) (interface{}, error) {
+	defaultPoolOnce.Do(func() {
+		defaultPool = new(RpcPool)
+		defaultPool.Initialise(RpcPoolConfig{IdleTimeout: time.Minute})
+	})
+	// 0 waits forever like RpcRequestSimple
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeOut > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(timeOut*1000*1000*1000))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	return defaultPool.RpcRequest(ctx, serverAddr, serverPort, rpcFuncName, rpcArg)
+}
@@ -0,0 +1,143 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRpcPoolLimits(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	pool := new(RpcPool)
+	pool.Initialise(RpcPoolConfig{MaxConns: 2, MaxInFlight: 2, TimeOut: 3, JsonRpc2: true})
+	defer pool.Close()
+
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := pool.RpcRequest(context.Background(), "127.0.0.1", port, "slow", nil)
+			if err != nil || result != "late" {
+				t.Error("unexpected result", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if time.Since(start) < 400*time.Millisecond {
+		t.Fatal("expected at most 2 calls in flight", time.Since(start))
+	}
+	stats := pool.Stats()
+	if stats.OpenCount != 2 || stats.DialCount != 2 || stats.InFlight != 0 || stats.WaitCount != 2 {
+		t.Fatal("unexpected stats", stats)
+	}
+
+	// calls waiting for a slot give up with their context
+	go func() {
+		_, _ = pool.RpcRequest(context.Background(), "127.0.0.1", port, "slow", nil)
+	}()
+	go func() {
+		_, _ = pool.RpcRequest(context.Background(), "127.0.0.1", port, "slow", nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := pool.RpcRequest(ctx, "127.0.0.1", port, "echo", nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected the wait to time out", err)
+	}
+}
+
+func TestRpcPoolReconnect(t *testing.T) {
+	server := startTestServer(t, "127.0.0.1:0")
+	address := server.address()
+	_, portText, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portText)
+
+	pool := new(RpcPool)
+	pool.Initialise(RpcPoolConfig{IdleTimeout: 100 * time.Millisecond, TimeOut: 3, JsonRpc2: true})
+	defer pool.Close()
+
+	_, err := pool.RpcRequest(context.Background(), "127.0.0.1", uint16(port), "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection closed by the server is replaced
+	server.kill()
+	restarted := startTestServer(t, address)
+	defer restarted.kill()
+	time.Sleep(20 * time.Millisecond)
+	_, err = pool.RpcRequest(context.Background(), "127.0.0.1", uint16(port), "echo", nil)
+	if err != nil {
+		t.Fatal("expected the call to go out on a new connection", err)
+	}
+	stats := pool.Stats()
+	if stats.DialCount != 2 || stats.ClosedBroken != 1 || stats.OpenCount != 1 {
+		t.Fatal("unexpected stats", stats)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	stats = pool.Stats()
+	if stats.OpenCount != 0 || stats.ClosedIdleTimeout != 1 {
+		t.Fatal("expected the idle connection to be closed", stats)
+	}
+}
+
+func TestRpcRequestPooled(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+	defer stop()
+
+	result, err := RpcRequestPooled("127.0.0.1", port, 3, "echo", "x")
+	if err != nil || result == nil {
+		t.Fatal("unexpected result", result, err)
+	}
+	dials := defaultPool.Stats().DialCount
+	for i := 0; i < 3; i++ {
+		_, err = RpcRequestPooled("127.0.0.1", port, 3, "echo", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if defaultPool.Stats().DialCount != dials {
+		t.Fatal("expected the connection to be reused", defaultPool.Stats())
+	}
+	// 0 waits without timeout
+	_, err = RpcRequestPooled("127.0.0.1", port, 0, "echo", "x")
+	if err != nil {
+		t.Fatal("unexpected error without timeout", err)
+	}
+}
+
+func TestRpcPoolDialFallback(t *testing.T) {
+	port, _, stop := startJsonRpc2Server(t)
+
+	pool := new(RpcPool)
+	pool.Initialise(RpcPoolConfig{MaxConns: 2, TimeOut: 1, JsonRpc2: true})
+	defer pool.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.RpcRequest(context.Background(), "127.0.0.1", port, "slow", nil)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// the open connection is busy and no further one can be dialed
+	stop()
+	result, err := pool.RpcRequest(context.Background(), "127.0.0.1", port, "echo", []int{1})
+	if err != nil || result == nil {
+		t.Fatal("expected the busy connection to carry the call", result, err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats.OpenCount != 1 || stats.DialCount != 1 {
+		t.Fatal("unexpected stats", stats)
+	}
+}
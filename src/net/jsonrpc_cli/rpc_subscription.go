@@ -0,0 +1,284 @@
+package jsonrpc_cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// notifications of subscriptions not known yet are kept up to this count,
+// the server may send them before the client read the response of the subscribe call
+const maxEarlyNotifications = 256
+
+// RpcSubscription delivers the notifications of a namespace_subscribe call in order,
+// buffering them while the channel is not ready to receive
+type RpcSubscription struct {
+	registry  *subscriptionRegistry
+	namespace string
+	args      []interface{}
+	channel   chan<- json.RawMessage
+	mutex     *sync.Mutex
+	id        json.RawMessage
+	queue     []json.RawMessage
+	signal    chan struct{}
+	quit      chan struct{}
+	err       chan error
+	endOnce   *sync.Once
+}
+
+type subscriptionNotification struct {
+	Subscription json.RawMessage `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+type subscriptionRegistry struct {
+	mutex         *sync.Mutex
+	client        *RpcClient
+	subscriptions map[string]*RpcSubscription
+	early         map[string][]json.RawMessage
+	earlyCount    int
+	closed        bool
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	r := new(subscriptionRegistry)
+	r.mutex = new(sync.Mutex)
+	r.subscriptions = make(map[string]*RpcSubscription)
+	r.early = make(map[string][]json.RawMessage)
+	r.earlyCount = 0
+	r.closed = false
+	return r
+}
+
+func subscriptionKey(id json.RawMessage) string {
+	return string(bytes.TrimSpace(id))
+}
+
+// dispatch runs on the reader of the client and must not block
+func (r *subscriptionRegistry) dispatch(method string, params json.RawMessage) {
+	if !strings.HasSuffix(method, "_subscription") {
+		return
+	}
+	var notification subscriptionNotification
+	if json.Unmarshal(params, &notification) != nil || len(notification.Subscription) == 0 {
+		return
+	}
+	key := subscriptionKey(notification.Subscription)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sub, ok := r.subscriptions[key]
+	if ok {
+		sub.enqueue(notification.Result)
+		return
+	}
+	if r.earlyCount >= maxEarlyNotifications {
+		r.early = make(map[string][]json.RawMessage)
+		r.earlyCount = 0
+	}
+	r.early[key] = append(r.early[key], notification.Result)
+	r.earlyCount++
+}
+
+// setClient keeps the client the registry belongs to, its calls see later changes of its settings
+func (r *subscriptionRegistry) setClient(client *RpcClient) {
+	r.mutex.Lock()
+	r.client = client
+	r.mutex.Unlock()
+}
+
+func (r *subscriptionRegistry) getClient() *RpcClient {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.client
+}
+
+func (r *subscriptionRegistry) subscribe(sub *RpcSubscription) error {
+	var id json.RawMessage
+	err := r.getClient().RpcCall(sub.namespace+"_subscribe", sub.args, &id)
+	if err != nil {
+		return err
+	}
+	if len(id) == 0 || bytes.Equal(id, []byte("null")) {
+		return errors.New("invalid subscription id")
+	}
+	key := subscriptionKey(id)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return rpc.ErrShutdown
+	}
+	sub.mutex.Lock()
+	sub.id = id
+	sub.mutex.Unlock()
+	r.subscriptions[key] = sub
+	early := r.early[key]
+	delete(r.early, key)
+	r.earlyCount = r.earlyCount - len(early)
+	for _, result := range early {
+		sub.enqueue(result)
+	}
+	return nil
+}
+
+// resubscribe subscribes again after a reconnect, the server forgot the subscriptions of the lost connection
+func (r *subscriptionRegistry) resubscribe() {
+	r.mutex.Lock()
+	subs := make([]*RpcSubscription, 0, len(r.subscriptions))
+	for _, sub := range r.subscriptions {
+		subs = append(subs, sub)
+	}
+	r.subscriptions = make(map[string]*RpcSubscription)
+	r.early = make(map[string][]json.RawMessage)
+	r.earlyCount = 0
+	r.mutex.Unlock()
+
+	for _, sub := range subs {
+		if sub.ended() {
+			continue
+		}
+		err := r.subscribe(sub)
+		if err != nil {
+			sub.end(err)
+		}
+	}
+}
+
+func (r *subscriptionRegistry) remove(sub *RpcSubscription) json.RawMessage {
+	id := sub.Id()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := subscriptionKey(id)
+	if r.subscriptions[key] == sub {
+		delete(r.subscriptions, key)
+	}
+	return id
+}
+
+// closeAll ends every subscription with err, later subscribe calls fail
+func (r *subscriptionRegistry) closeAll(err error) {
+	r.mutex.Lock()
+	r.closed = true
+	subs := r.subscriptions
+	r.subscriptions = make(map[string]*RpcSubscription)
+	r.mutex.Unlock()
+	for _, sub := range subs {
+		sub.end(err)
+	}
+}
+
+// RpcSubscribe calls namespace_subscribe with args and sends the results of the namespace_subscription
+// notifications to channel. It needs a client of RpcConnectV2 or RpcConnectWs, with RpcConnectWs
+// the subscription is made again after a reconnect. Notifications sent while the connection was
+// down are lost.
+func (g RpcClient) RpcSubscribe(namespace string, channel chan<- json.RawMessage, args ...interface{}) (*RpcSubscription, error) {
+	if g.subscriptions == nil {
+		return nil, errors.New("subscriptions need a JSON-RPC 2.0 client with a persistent connection")
+	}
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	sub := new(RpcSubscription)
+	sub.registry = g.subscriptions
+	sub.namespace = namespace
+	sub.args = args
+	sub.channel = channel
+	sub.mutex = new(sync.Mutex)
+	sub.id = nil
+	sub.queue = make([]json.RawMessage, 0)
+	sub.signal = make(chan struct{}, 1)
+	sub.quit = make(chan struct{})
+	sub.err = make(chan error, 1)
+	sub.endOnce = new(sync.Once)
+
+	err := g.subscriptions.subscribe(sub)
+	if err != nil {
+		return nil, err
+	}
+	go sub.deliver()
+	return sub, nil
+}
+
+// Id is the subscription id the server gave, it changes when resubscribing after a reconnect
+func (s *RpcSubscription) Id() json.RawMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.id
+}
+
+// Err receives the error which ended the subscription, e.g. a failed resubscribe or the
+// client disconnecting. It is closed once the subscription ended, without error by Unsubscribe.
+func (s *RpcSubscription) Err() <-chan error {
+	return s.err
+}
+
+// Unsubscribe stops the delivery and calls namespace_unsubscribe
+func (s *RpcSubscription) Unsubscribe() error {
+	if !s.end(nil) {
+		return nil
+	}
+	id := s.registry.remove(s)
+	return s.registry.getClient().RpcCall(s.namespace+"_unsubscribe", []json.RawMessage{id}, nil)
+}
+
+func (s *RpcSubscription) enqueue(result json.RawMessage) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, result)
+	s.mutex.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *RpcSubscription) deliver() {
+	for {
+		select {
+		case <-s.signal:
+		case <-s.quit:
+			return
+		}
+		for {
+			s.mutex.Lock()
+			if len(s.queue) == 0 {
+				s.mutex.Unlock()
+				break
+			}
+			result := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mutex.Unlock()
+			select {
+			case s.channel <- result:
+			case <-s.quit:
+				return
+			}
+		}
+	}
+}
+
+func (s *RpcSubscription) ended() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// end stops the subscription once, it returns false when it had ended before
+func (s *RpcSubscription) end(err error) bool {
+	ending := false
+	s.endOnce.Do(func() {
+		ending = true
+		close(s.quit)
+		if err != nil {
+			s.err <- err
+		}
+		close(s.err)
+	})
+	return ending
+}
@@ -0,0 +1,61 @@
+package jsonrpc_cli
+
+import (
+	"github.com/mutalisk999/go-lib/src/net/websocket"
+	"net/http"
+)
+
+type WsConfig struct {
+	// ws:// or wss:// url
+	URL     string
+	Headers map[string]string
+	// timeout of connecting and of the handshake in seconds
+	TimeOut float64
+	// seconds before reconnecting once the connection was lost, 0 disables reconnecting
+	ReconnectInterval float64
+	// the delay doubles per failed attempt up to MaxReconnectInterval, 0 keeps ReconnectInterval
+	MaxReconnectInterval float64
+	// limit of received messages, 0 uses websocket.DefaultMaxMessageSize
+	MaxMessageSize int64
+}
+
+// wsTransport sends every message as a text message over a single connection
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func newWsTransport(config WsConfig) (*wsTransport, error) {
+	header := make(http.Header)
+	for key, value := range config.Headers {
+		header.Set(key, value)
+	}
+	conn, err := websocket.Dial(config.URL, header, config.TimeOut)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxMessageSize > 0 {
+		conn.SetMaxMessageSize(config.MaxMessageSize)
+	}
+	t := new(wsTransport)
+	t.conn = conn
+	return t, nil
+}
+
+func wsEndpoint(config WsConfig) endpoint {
+	return endpoint{address: config.URL, dial: func() (transport, error) {
+		return newWsTransport(config)
+	}}
+}
+
+func (t *wsTransport) writeMessage(message []byte) error {
+	return t.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+func (t *wsTransport) readMessage() ([]byte, error) {
+	_, message, err := t.conn.ReadMessage()
+	return message, err
+}
+
+func (t *wsTransport) close() error {
+	return t.conn.Close()
+}
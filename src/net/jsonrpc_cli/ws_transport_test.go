@@ -0,0 +1,187 @@
+package jsonrpc_cli
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mutalisk999/go-lib/src/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startWsServer answers like startJsonRpc2Server. test_subscribe [n] sends n notifications right after
+// its response, test_unsubscribe ids go to the returned channel and "drop" closes the connection.
+func startWsServer(t *testing.T) (string, chan string, func()) {
+	var subscriptionSeq uint64
+	unsubscribed := make(chan string, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		send := func(message interface{}) {
+			encoded, _ := json.Marshal(message)
+			_ = conn.WriteMessage(websocket.TextMessage, encoded)
+		}
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request testRequest
+			_ = json.Unmarshal(message, &request)
+			switch request.Method {
+			case "drop":
+				return
+			case "test_subscribe":
+				var count []int
+				_ = json.Unmarshal(request.Params, &count)
+				id := "0x" + strconv.FormatUint(atomic.AddUint64(&subscriptionSeq, 1), 16)
+				send(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": id})
+				for i := 0; i < count[0]; i++ {
+					send(map[string]interface{}{"jsonrpc": "2.0", "method": "test_subscription",
+						"params": map[string]interface{}{"subscription": id, "result": i}})
+				}
+			case "test_unsubscribe":
+				var ids []string
+				_ = json.Unmarshal(request.Params, &ids)
+				unsubscribed <- ids[0]
+				send(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": true})
+			default:
+				send(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": request.Params})
+			}
+		}
+	}))
+	return "ws" + strings.TrimPrefix(server.URL, "http"), unsubscribed, server.Close
+}
+
+func expectNotifications(t *testing.T, notifications chan json.RawMessage, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case result := <-notifications:
+			if string(result) != strconv.Itoa(i) {
+				t.Fatal("unexpected notification", i, string(result))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("notification not received", i)
+		}
+	}
+}
+
+func TestWsSubscription(t *testing.T) {
+	url, unsubscribed, stop := startWsServer(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectWs(WsConfig{URL: url, TimeOut: 3, ReconnectInterval: 0.05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := rpcClient.RpcRequest("echo", []int{i})
+			if err != nil || !reflect.DeepEqual(result, []interface{}{float64(i)}) {
+				t.Error("unexpected result", result, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	notifications := make(chan json.RawMessage)
+	sub, err := rpcClient.RpcSubscribe("test", notifications, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNotifications(t, notifications, 3)
+	firstId := string(sub.Id())
+
+	// the call waiting on the lost connection fails, the subscription is made again
+	_, err = rpcClient.RpcRequest("drop", nil)
+	rpcErr, ok := err.(*RpcError)
+	if !ok || rpcErr.Code != TransportErrorCode {
+		t.Fatal("expected a transport error", err)
+	}
+	expectNotifications(t, notifications, 3)
+	if string(sub.Id()) == firstId {
+		t.Fatal("expected a new subscription id")
+	}
+	_, err = rpcClient.RpcRequest("echo", nil)
+	if err != nil {
+		t.Fatal("client unusable after reconnecting", err)
+	}
+
+	// subscription calls pass the settings made after subscribing
+	methods := make(chan string, 1)
+	rpcClient.Use(func(ctx context.Context, method string, params interface{}, reply interface{}, next RpcInvoker) error {
+		methods <- method
+		return next(ctx, method, params, reply)
+	})
+	err = sub.Unsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method := <-methods; method != "test_unsubscribe" {
+		t.Fatal("expected the interceptor to see the unsubscribe", method)
+	}
+	if `"`+<-unsubscribed+`"` != string(sub.Id()) {
+		t.Fatal("unexpected unsubscribe")
+	}
+	_, ok = <-sub.Err()
+	if ok {
+		t.Fatal("expected Err to be closed without error")
+	}
+}
+
+func TestWsNoReconnect(t *testing.T) {
+	url, _, stop := startWsServer(t)
+	defer stop()
+
+	rpcClient := new(RpcClient)
+	err := rpcClient.RpcConnectWs(WsConfig{URL: url, TimeOut: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	notifications := make(chan json.RawMessage, 4)
+	sub, err := rpcClient.RpcSubscribe("test", notifications, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rpcClient.RpcRequest("drop", nil)
+	if err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	select {
+	case err = <-sub.Err():
+		if err == nil {
+			t.Fatal("expected the subscription to end with an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription not ended")
+	}
+	_, err = rpcClient.RpcRequest("echo", nil)
+	if err == nil {
+		t.Fatal("expected the client to be shut down")
+	}
+
+	httpClient := new(RpcClient)
+	_ = httpClient.RpcConnectHttp(HttpConfig{URL: "http://127.0.0.1:1"})
+	defer httpClient.RpcDisConnect()
+	_, err = httpClient.RpcSubscribe("test", notifications)
+	if err == nil {
+		t.Fatal("expected subscriptions to need a persistent connection")
+	}
+}
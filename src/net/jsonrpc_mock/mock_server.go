@@ -0,0 +1,287 @@
+package jsonrpc_mock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_srv"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// MockCall is a call the mock server received
+type MockCall struct {
+	Method string
+	Params json.RawMessage
+}
+
+// Expectation answers the calls of a method, optionally only those with matching params
+type Expectation struct {
+	mutex  *sync.Mutex
+	method string
+	// params are only compared when hasParams is set
+	hasParams bool
+	params    interface{}
+	// paramsErr is set when WithParams could not encode the params, the expectation matches nothing
+	paramsErr error
+	result    interface{}
+	err       *jsonrpc_srv.RpcError
+	delay     time.Duration
+	times     int
+	called    int
+}
+
+// WithParams restricts the expectation to calls whose params are the JSON of params,
+// e.g. []interface{}{1, "a"} or a struct for named params.
+// Params which fail to encode match no call and make Verify fail.
+func (e *Expectation) WithParams(params interface{}) *Expectation {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.hasParams = true
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		e.params = nil
+		e.paramsErr = errors.New(fmt.Sprintf("mock: %s WithParams: %s", e.method, err.Error()))
+		return e
+	}
+	e.params = normalize(encoded)
+	e.paramsErr = nil
+	return e
+}
+
+func (e *Expectation) Return(result interface{}) *Expectation {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.result = result
+	e.err = nil
+	return e
+}
+
+func (e *Expectation) ReturnError(code int, message string, data interface{}) *Expectation {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.result = nil
+	e.err = &jsonrpc_srv.RpcError{Code: code, Message: message, Data: data}
+	return e
+}
+
+// Times limits the calls the expectation answers, 0 answers any number of calls.
+// Verify fails unless a limited expectation got all its calls.
+func (e *Expectation) Times(times int) *Expectation {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.times = times
+	return e
+}
+
+// Delay holds the response back for timeOut seconds
+func (e *Expectation) Delay(timeOut float64) *Expectation {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.delay = time.Duration(timeOut * 1000 * 1000 * 1000)
+	return e
+}
+
+// take counts a call when the expectation matches and is not used up
+func (e *Expectation) take(params interface{}) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.paramsErr != nil || e.times > 0 && e.called >= e.times {
+		return false
+	}
+	if e.hasParams && !reflect.DeepEqual(e.params, params) {
+		return false
+	}
+	e.called++
+	return true
+}
+
+// normalize decodes JSON for comparing, absent params and null are the same
+func normalize(raw json.RawMessage) interface{} {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	}
+	var value interface{}
+	if json.Unmarshal(raw, &value) != nil {
+		return string(raw)
+	}
+	return value
+}
+
+// MockServer is an in-process JSON-RPC 2.0 server answering calls from expectations,
+// served over TCP and HTTP on the loopback interface
+type MockServer struct {
+	mutex        *sync.Mutex
+	server       *jsonrpc_srv.RpcServer
+	httpServer   *http.Server
+	expectations map[string][]*Expectation
+	calls        []MockCall
+}
+
+func (m *MockServer) Initialise(serverName string) {
+	m.mutex = new(sync.Mutex)
+	m.server = new(jsonrpc_srv.RpcServer)
+	m.server.Initialise(serverName)
+	m.httpServer = nil
+	m.expectations = make(map[string][]*Expectation)
+	m.calls = make([]MockCall, 0)
+}
+
+// ServeTcp serves newline delimited JSON on a free port of 127.0.0.1
+func (m *MockServer) ServeTcp() (uint16, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		_ = m.server.Serve(listener)
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+// ServeHttp serves HTTP POSTs on a free port of 127.0.0.1 and returns the url
+func (m *MockServer) ServeHttp() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	m.mutex.Lock()
+	m.httpServer = &http.Server{Handler: m.server}
+	httpServer := m.httpServer
+	m.mutex.Unlock()
+	go func() {
+		_ = httpServer.Serve(listener)
+	}()
+	return "http://" + listener.Addr().String(), nil
+}
+
+// Expect adds an expectation for method, which answers with a null result until Return is called.
+// Expectations of a method are tried in the order they were added.
+func (m *MockServer) Expect(method string) *Expectation {
+	e := &Expectation{mutex: new(sync.Mutex), method: method}
+	m.mutex.Lock()
+	_, registered := m.expectations[method]
+	m.expectations[method] = append(m.expectations[method], e)
+	m.mutex.Unlock()
+	if !registered {
+		_ = m.server.RegisterFunc(method, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			return m.answer(ctx, method, params)
+		})
+	}
+	return e
+}
+
+func (m *MockServer) answer(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	normalized := normalize(params)
+	m.mutex.Lock()
+	m.calls = append(m.calls, MockCall{Method: method, Params: params})
+	var matched *Expectation
+	for _, e := range m.expectations[method] {
+		if e.take(normalized) {
+			matched = e
+			break
+		}
+	}
+	m.mutex.Unlock()
+	if matched == nil {
+		return nil, &jsonrpc_srv.RpcError{Code: jsonrpc_srv.ErrCodeInvalidParams,
+			Message: "mock: unexpected params " + string(params) + " of " + method}
+	}
+
+	matched.mutex.Lock()
+	delay, result, err := matched.delay, matched.result, matched.err
+	matched.mutex.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Calls returns the calls received so far, including unexpected ones
+func (m *MockServer) Calls() []MockCall {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]MockCall{}, m.calls...)
+}
+
+// Verify returns an error naming the expectations limited by Times which did not get all their calls,
+// or whose WithParams failed
+func (m *MockServer) Verify() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for method, expectations := range m.expectations {
+		for _, e := range expectations {
+			e.mutex.Lock()
+			times, called, paramsErr := e.times, e.called, e.paramsErr
+			e.mutex.Unlock()
+			if paramsErr != nil {
+				return paramsErr
+			}
+			if times > 0 && called < times {
+				return errors.New(fmt.Sprintf("mock: %s called %d of %d times", method, called, times))
+			}
+		}
+	}
+	return nil
+}
+
+// LoadRecording adds the calls of a Recorder file as expectations answered once each,
+// so the recorded session can be replayed without the real server
+func (m *MockServer) LoadRecording(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var recorded Recording
+		err = json.Unmarshal(line, &recorded)
+		if err != nil {
+			return errors.New("LoadRecording: " + err.Error())
+		}
+		e := m.Expect(recorded.Method).WithParams(recorded.Params).Times(1)
+		if recorded.Error != nil {
+			var data interface{}
+			if len(recorded.Error.Data) > 0 {
+				data = recorded.Error.Data
+			}
+			e.ReturnError(recorded.Error.Code, recorded.Error.Message, data)
+		} else {
+			e.Return(recorded.Result)
+		}
+	}
+	return scanner.Err()
+}
+
+// Close stops serving and disconnects the clients
+func (m *MockServer) Close() {
+	m.mutex.Lock()
+	httpServer := m.httpServer
+	m.mutex.Unlock()
+	if httpServer != nil {
+		_ = httpServer.Close()
+	}
+	m.server.Close()
+}
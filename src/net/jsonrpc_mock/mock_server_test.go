@@ -0,0 +1,131 @@
+package jsonrpc_mock
+
+import (
+	"context"
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_cli"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func startMock(t *testing.T) (*MockServer, *jsonrpc_cli.RpcClient) {
+	mock := new(MockServer)
+	mock.Initialise("mock")
+	port, err := mock.ServeTcp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcClient := new(jsonrpc_cli.RpcClient)
+	err = rpcClient.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		mock.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		rpcClient.RpcDisConnect()
+		mock.Close()
+	})
+	return mock, rpcClient
+}
+
+func TestMockExpectations(t *testing.T) {
+	mock, rpcClient := startMock(t)
+	mock.Expect("add").WithParams([]int{1, 2}).Return(3).Times(1)
+	mock.Expect("add").WithParams([]int{2, 2}).Return(4)
+	mock.Expect("fail").ReturnError(-32001, "no funds", map[string]int{"balance": 0})
+	mock.Expect("ping")
+
+	result, err := rpcClient.RpcRequest("add", []int{1, 2})
+	if err != nil || result != float64(3) {
+		t.Fatal("unexpected result", result, err)
+	}
+	// the first expectation is used up
+	_, err = rpcClient.RpcRequest("add", []int{1, 2})
+	rpcErr, ok := err.(*jsonrpc_cli.RpcError)
+	if !ok || rpcErr.Code != -32602 {
+		t.Fatal("expected unexpected params", err)
+	}
+	for i := 0; i < 2; i++ {
+		result, err = rpcClient.RpcRequest("add", []int{2, 2})
+		if err != nil || result != float64(4) {
+			t.Fatal("unexpected result", result, err)
+		}
+	}
+	_, err = rpcClient.RpcRequest("fail", nil)
+	rpcErr, ok = err.(*jsonrpc_cli.RpcError)
+	if !ok || rpcErr.Code != -32001 || rpcErr.Message != "no funds" || string(rpcErr.Data) != `{"balance":0}` {
+		t.Fatal("unexpected error", err)
+	}
+	result, err = rpcClient.RpcRequest("ping", nil)
+	if err != nil || result != nil {
+		t.Fatal("expected a null result", result, err)
+	}
+	_, err = rpcClient.RpcRequest("unknown", nil)
+	rpcErr, ok = err.(*jsonrpc_cli.RpcError)
+	if !ok || rpcErr.Code != -32601 {
+		t.Fatal("expected method not found", err)
+	}
+
+	calls := mock.Calls()
+	if len(calls) != 6 || calls[0].Method != "add" || string(calls[0].Params) != "[1,2]" {
+		t.Fatal("unexpected calls", calls)
+	}
+	if mock.Verify() != nil {
+		t.Fatal(mock.Verify())
+	}
+	mock.Expect("later").Times(2)
+	if mock.Verify() == nil {
+		t.Fatal("expected verify to fail")
+	}
+}
+
+func TestMockInvalidParams(t *testing.T) {
+	mock, rpcClient := startMock(t)
+	mock.Expect("send").WithParams(make(chan int)).Return(true)
+
+	_, err := rpcClient.RpcRequest("send", nil)
+	rpcErr, ok := err.(*jsonrpc_cli.RpcError)
+	if !ok || rpcErr.Code != -32602 {
+		t.Fatal("expected the expectation to match nothing", err)
+	}
+	if mock.Verify() == nil {
+		t.Fatal("expected verify to report the params")
+	}
+}
+
+func TestMockDelayHttp(t *testing.T) {
+	mock := new(MockServer)
+	mock.Initialise("mock")
+	defer mock.Close()
+	url, err := mock.ServeHttp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.Expect("slow").Return("done").Delay(0.2)
+	mock.Expect("named").WithParams(map[string]string{"name": "x"}).Return(true)
+
+	rpcClient := new(jsonrpc_cli.RpcClient)
+	err = rpcClient.RpcConnectHttp(jsonrpc_cli.HttpConfig{URL: url, TimeOut: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.RpcDisConnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = rpcClient.RpcRequestContext(ctx, "slow", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected the delay to exceed the deadline", err)
+	}
+	start := time.Now()
+	result, err := rpcClient.RpcRequest("slow", nil)
+	if err != nil || result != "done" || time.Since(start) < 200*time.Millisecond {
+		t.Fatal("unexpected result", result, err)
+	}
+	result, err = rpcClient.RpcRequest("named", struct {
+		Name string `json:"name"`
+	}{"x"})
+	if err != nil || !reflect.DeepEqual(result, true) {
+		t.Fatal("unexpected result", result, err)
+	}
+}
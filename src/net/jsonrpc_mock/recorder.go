@@ -0,0 +1,80 @@
+package jsonrpc_mock
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_cli"
+	"os"
+	"sync"
+)
+
+// Recording is a call and its response, stored one per line by Recorder
+type Recording struct {
+	Method string                `json:"method"`
+	Params json.RawMessage       `json:"params"`
+	Result json.RawMessage       `json:"result,omitempty"`
+	Error  *jsonrpc_cli.RpcError `json:"error,omitempty"`
+}
+
+// Recorder writes the calls of a client and their responses to a file, which
+// MockServer.LoadRecording replays. Calls failing without an error object, e.g. on
+// a network error, are not recorded.
+type Recorder struct {
+	mutex   *sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Initialise creates or truncates the file at path
+func (r *Recorder) Initialise(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	r.mutex = new(sync.Mutex)
+	r.file = file
+	r.encoder = json.NewEncoder(file)
+	return nil
+}
+
+// Interceptor records every call passing it, add it to a client with RpcClient.Use
+func (r *Recorder) Interceptor() jsonrpc_cli.RpcInterceptor {
+	return func(ctx context.Context, method string, params interface{}, reply interface{}, next jsonrpc_cli.RpcInvoker) error {
+		var raw json.RawMessage
+		err := next(ctx, method, params, &raw)
+		recorded := Recording{Method: method}
+		recorded.Params, _ = jsonrpc_cli.EncodeParams(params)
+		rpcErr, ok := err.(*jsonrpc_cli.RpcError)
+		if err != nil && !ok {
+			return err
+		}
+		if ok {
+			recorded.Error = rpcErr
+		} else {
+			recorded.Result = raw
+			if len(recorded.Result) == 0 {
+				recorded.Result = json.RawMessage("null")
+			}
+		}
+
+		r.mutex.Lock()
+		writeErr := r.encoder.Encode(recorded)
+		r.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+		if reply == nil {
+			return nil
+		}
+		return json.Unmarshal(recorded.Result, reply)
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,60 @@
+package jsonrpc_mock
+
+import (
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_cli"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	live, rpcClient := startMock(t)
+	live.Expect("balance").WithParams([]string{"alice"}).Return(map[string]int{"amount": 10})
+	live.Expect("transfer").ReturnError(-32001, "no funds", nil)
+
+	recorder := new(Recorder)
+	err := recorder.Initialise(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcClient.Use(recorder.Interceptor())
+	var balance struct {
+		Amount int `json:"amount"`
+	}
+	err = rpcClient.RpcCall("balance", []string{"alice"}, &balance)
+	if err != nil || balance.Amount != 10 {
+		t.Fatal("unexpected result", balance, err)
+	}
+	_, err = rpcClient.RpcRequest("transfer", []interface{}{"alice", 20})
+	if _, ok := err.(*jsonrpc_cli.RpcError); !ok {
+		t.Fatal("expected an error object", err)
+	}
+	err = recorder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay, replayClient := startMock(t)
+	err = replay.LoadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := replayClient.RpcRequest("balance", []string{"alice"})
+	if err != nil || !reflect.DeepEqual(result, map[string]interface{}{"amount": float64(10)}) {
+		t.Fatal("unexpected replayed result", result, err)
+	}
+	_, err = replayClient.RpcRequest("transfer", []interface{}{"alice", 20})
+	rpcErr, ok := err.(*jsonrpc_cli.RpcError)
+	if !ok || rpcErr.Code != -32001 || rpcErr.Message != "no funds" || len(rpcErr.Data) != 0 {
+		t.Fatal("unexpected replayed error", err)
+	}
+	// every recorded call is answered once
+	_, err = replayClient.RpcRequest("balance", []string{"alice"})
+	if err == nil {
+		t.Fatal("expected the replay to be used up")
+	}
+	if replay.Verify() != nil {
+		t.Fatal(replay.Verify())
+	}
+}
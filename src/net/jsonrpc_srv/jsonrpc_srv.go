@@ -0,0 +1,442 @@
+package jsonrpc_srv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/sched/goroutine_mgr"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+const JsonRpcVersion = "2.0"
+
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	// returned for handler errors which are not an *RpcError
+	ErrCodeServer = -32000
+)
+
+const DefaultMaxRequestSize = 4 * 1024 * 1024
+
+// DefaultMaxConcurrentRequests bounds the messages handled at the same time
+const DefaultMaxConcurrentRequests = 1024
+
+// RpcError is returned by handlers to answer with a specific error object
+type RpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RpcError) Error() string {
+	return "rpc error " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+type jsonRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// jsonResponse holds either Result or Error
+type jsonResponse struct {
+	Version string
+	Id      json.RawMessage
+	Result  interface{}
+	Error   *RpcError
+}
+
+// MarshalJSON sends a null result of a successful response but no result with an error
+func (r jsonResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			Version string          `json:"jsonrpc"`
+			Id      json.RawMessage `json:"id"`
+			Error   *RpcError       `json:"error"`
+		}{r.Version, r.Id, r.Error})
+	}
+	return json.Marshal(struct {
+		Version string          `json:"jsonrpc"`
+		Id      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"`
+	}{r.Version, r.Id, r.Result})
+}
+
+type serverConn struct {
+	conn       net.Conn
+	writeMutex *sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+	slots      chan struct{}
+}
+
+func (sc *serverConn) writeMessage(message []byte) error {
+	sc.writeMutex.Lock()
+	defer sc.writeMutex.Unlock()
+	_, err := sc.conn.Write(append(message, '\n'))
+	return err
+}
+
+// RpcServer serves JSON-RPC 2.0 over newline delimited TCP streams and HTTP POSTs
+type RpcServer struct {
+	mutex          *sync.RWMutex
+	serverName     string
+	maxRequestSize int64
+	requestSlots   chan struct{}
+	handlers       map[string]*rpcHandler
+	goroutineMgr   *goroutine_mgr.GoroutineManager
+	listener       net.Listener
+	conns          map[*serverConn]bool
+	closed         bool
+}
+
+func (s *RpcServer) Initialise(serverName string) {
+	s.mutex = new(sync.RWMutex)
+	s.serverName = serverName
+	s.maxRequestSize = DefaultMaxRequestSize
+	s.requestSlots = make(chan struct{}, DefaultMaxConcurrentRequests)
+	s.handlers = make(map[string]*rpcHandler)
+	s.goroutineMgr = new(goroutine_mgr.GoroutineManager)
+	s.goroutineMgr.Initialise(serverName + ".GoroutineMgr")
+	s.listener = nil
+	s.conns = make(map[*serverConn]bool)
+	s.closed = false
+}
+
+// SetMaxRequestSize limits the size of HTTP request bodies and of messages read from TCP streams
+func (s *RpcServer) SetMaxRequestSize(maxRequestSize int64) {
+	s.mutex.Lock()
+	s.maxRequestSize = maxRequestSize
+	s.mutex.Unlock()
+}
+
+// SetMaxConcurrentRequests bounds the messages handled at the same time, 0 means unlimited.
+// A TCP connection stops reading while no slot is free. It applies to the connections served afterwards.
+func (s *RpcServer) SetMaxConcurrentRequests(maxConcurrentRequests int) {
+	s.mutex.Lock()
+	s.requestSlots = nil
+	if maxConcurrentRequests > 0 {
+		s.requestSlots = make(chan struct{}, maxConcurrentRequests)
+	}
+	s.mutex.Unlock()
+}
+
+func (s *RpcServer) register(method string, handler *rpcHandler) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.handlers[method]
+	if ok {
+		return errors.New(fmt.Sprintf("Register: method %s already registered", method))
+	}
+	s.handlers[method] = handler
+	return nil
+}
+
+// RegisterFunc binds method to fn, a function of one of the forms
+//
+//	func([ctx context.Context,] [params P]) (R, error)
+//	func([ctx context.Context,] [params P]) error
+//
+// Named params are decoded into a struct or map P rejecting unknown fields, positional params into
+// a slice P, and a single positional param into any other P. P is validated when it has a Validate method.
+// ctx is cancelled when the connection or the HTTP request ends.
+func (s *RpcServer) RegisterFunc(method string, fn interface{}) error {
+	handler, err := newRpcHandler(reflect.ValueOf(fn))
+	if err != nil {
+		return errors.New("RegisterFunc: " + method + ": " + err.Error())
+	}
+	return s.register(method, handler)
+}
+
+// RegisterObject binds the exported methods of obj which have the form of a RegisterFunc handler.
+// Method names start lower case and get prefix and an underscore prepended, e.g. eth_blockNumber.
+// Other methods are skipped, an error is returned when none qualifies.
+func (s *RpcServer) RegisterObject(prefix string, obj interface{}) error {
+	value := reflect.ValueOf(obj)
+	registered := 0
+	for i := 0; i < value.NumMethod(); i++ {
+		handler, err := newRpcHandler(value.Method(i))
+		if err != nil {
+			continue
+		}
+		method := lowerFirst(value.Type().Method(i).Name)
+		if prefix != "" {
+			method = prefix + "_" + method
+		}
+		err = s.register(method, handler)
+		if err != nil {
+			return err
+		}
+		registered++
+	}
+	if registered == 0 {
+		return errors.New("RegisterObject: no suitable method")
+	}
+	return nil
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *jsonResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &jsonResponse{Version: JsonRpcVersion, Id: id, Error: &RpcError{Code: code, Message: message}}
+}
+
+// handleRequest returns nil for notifications
+func (s *RpcServer) handleRequest(ctx context.Context, raw json.RawMessage) *jsonResponse {
+	var request jsonRequest
+	err := json.Unmarshal(raw, &request)
+	if err != nil || request.Version != JsonRpcVersion || request.Method == "" {
+		return errorResponse(request.Id, ErrCodeInvalidRequest, "invalid request")
+	}
+	notification := len(request.Id) == 0
+
+	s.mutex.RLock()
+	handler, ok := s.handlers[request.Method]
+	s.mutex.RUnlock()
+	var result interface{}
+	if !ok {
+		err = &RpcError{Code: ErrCodeMethodNotFound, Message: "method not found: " + request.Method}
+	} else {
+		result, err = handler.call(ctx, request.Params)
+	}
+	if notification {
+		return nil
+	}
+	if err != nil {
+		rpcErr, ok := err.(*RpcError)
+		if !ok {
+			rpcErr = &RpcError{Code: ErrCodeServer, Message: err.Error()}
+		}
+		return &jsonResponse{Version: JsonRpcVersion, Id: request.Id, Error: rpcErr}
+	}
+	return &jsonResponse{Version: JsonRpcVersion, Id: request.Id, Result: result}
+}
+
+// handleMessage answers a single request or a batch, nil means nothing is to be sent back
+func (s *RpcServer) handleMessage(ctx context.Context, message []byte) []byte {
+	message = bytes.TrimSpace(message)
+	if len(message) == 0 || !json.Valid(message) {
+		response, _ := json.Marshal(errorResponse(nil, ErrCodeParse, "parse error"))
+		return response
+	}
+	if message[0] != '[' {
+		response := s.handleRequest(ctx, message)
+		if response == nil {
+			return nil
+		}
+		return s.marshalResponse(response)
+	}
+
+	var batch []json.RawMessage
+	err := json.Unmarshal(message, &batch)
+	if err != nil || len(batch) == 0 {
+		response, _ := json.Marshal(errorResponse(nil, ErrCodeInvalidRequest, "invalid request"))
+		return response
+	}
+	responses := make([]json.RawMessage, 0, len(batch))
+	for _, raw := range batch {
+		response := s.handleRequest(ctx, raw)
+		if response != nil {
+			responses = append(responses, s.marshalResponse(response))
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	encoded, _ := json.Marshal(responses)
+	return encoded
+}
+
+func (s *RpcServer) marshalResponse(response *jsonResponse) []byte {
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		encoded, _ = json.Marshal(errorResponse(response.Id, ErrCodeInternal, "invalid result: "+err.Error()))
+	}
+	return encoded
+}
+
+var errRequestTooLarge = errors.New("request too large")
+
+// messageReader fails once about limit bytes were read for one message, the decoder reads ahead a little
+type messageReader struct {
+	reader    io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, errRequestTooLarge
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[0:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining = r.remaining - int64(n)
+	return n, err
+}
+
+// Serve accepts connections until the listener or the server is closed
+func (s *RpcServer) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.RLock()
+			closed := s.closed
+			s.mutex.RUnlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.goroutineMgr.GoroutineCreateP1(s.serverName+".Conn", s.connCallBack, conn)
+	}
+}
+
+func (s *RpcServer) connCallBack(g goroutine_mgr.Goroutine, conn interface{}) {
+	defer g.OnQuit()
+	s.ServeConn(conn.(net.Conn))
+}
+
+// ServeConn serves a stream of JSON messages until the connection is closed,
+// every message is handled in its own goroutine so responses may come out of order.
+// Reading waits while SetMaxConcurrentRequests messages are being handled.
+func (s *RpcServer) ServeConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := &serverConn{conn: conn, writeMutex: new(sync.Mutex), ctx: ctx, cancel: cancel}
+	s.mutex.Lock()
+	sc.slots = s.requestSlots
+	if s.closed {
+		s.mutex.Unlock()
+		cancel()
+		_ = conn.Close()
+		return
+	}
+	s.conns[sc] = true
+	maxRequestSize := s.maxRequestSize
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, sc)
+		s.mutex.Unlock()
+		cancel()
+		_ = conn.Close()
+	}()
+
+	reader := &messageReader{reader: conn, limit: maxRequestSize}
+	decoder := json.NewDecoder(reader)
+	for {
+		reader.remaining = reader.limit
+		var message json.RawMessage
+		err := decoder.Decode(&message)
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				response, _ := json.Marshal(errorResponse(nil, ErrCodeParse, "parse error"))
+				_ = sc.writeMessage(response)
+			}
+			return
+		}
+		if !acquireSlot(ctx, sc.slots) {
+			return
+		}
+		s.goroutineMgr.GoroutineCreateP2(s.serverName+".Request", s.requestCallBack, sc, []byte(message))
+	}
+}
+
+func (s *RpcServer) requestCallBack(g goroutine_mgr.Goroutine, sc interface{}, message interface{}) {
+	defer g.OnQuit()
+	conn := sc.(*serverConn)
+	defer releaseSlot(conn.slots)
+	response := s.handleMessage(conn.ctx, message.([]byte))
+	if response != nil {
+		_ = conn.writeMessage(response)
+	}
+}
+
+// acquireSlot waits for a free slot until ctx is done, nil slots are unlimited
+func acquireSlot(ctx context.Context, slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// ServeHTTP answers JSON-RPC 2.0 POSTs, notifications get 204 No Content
+func (s *RpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mutex.RLock()
+	maxRequestSize := s.maxRequestSize
+	slots := s.requestSlots
+	s.mutex.RUnlock()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !acquireSlot(r.Context(), slots) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer releaseSlot(slots)
+	response := s.handleMessage(r.Context(), body)
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+// Close stops Serve and disconnects the served connections
+func (s *RpcServer) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	listener := s.listener
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mutex.Unlock()
+
+	if listener != nil {
+		_ = listener.Close()
+	}
+	for _, sc := range conns {
+		sc.cancel()
+		_ = sc.conn.Close()
+	}
+}
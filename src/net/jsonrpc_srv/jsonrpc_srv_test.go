@@ -0,0 +1,328 @@
+package jsonrpc_srv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/mutalisk999/go-lib/src/net/jsonrpc_cli"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type transferParams struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+}
+
+func (p transferParams) Validate() error {
+	if p.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+type panicParams struct {
+	Name string `json:"name"`
+}
+
+func (p panicParams) Validate() error {
+	panic("validate " + p.Name)
+}
+
+type account struct {
+	notified chan string
+}
+
+func (a *account) Balance(name string) (int64, error) {
+	return int64(len(name)), nil
+}
+
+func (a *account) Transfer(ctx context.Context, params transferParams) (string, error) {
+	return params.From + "->" + params.To, nil
+}
+
+func (a *account) Notify(message string) error {
+	a.notified <- message
+	return nil
+}
+
+func (a *account) Fail() error {
+	return &RpcError{Code: 42, Message: "custom"}
+}
+
+// not a handler, skipped by RegisterObject
+func (a *account) Helper(x, y int) int {
+	return x + y
+}
+
+func newTestServer(t *testing.T) (*RpcServer, *account) {
+	server := new(RpcServer)
+	server.Initialise("TestServer")
+	a := &account{notified: make(chan string, 4)}
+	err := server.RegisterObject("account", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterFunc("sum", func(numbers []int) (int, error) {
+		total := 0
+		for _, n := range numbers {
+			total = total + n
+		}
+		return total, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterFunc("panic", func() (int, error) {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterFunc("plain", func() error {
+		return errors.New("plain error")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterFunc("validatePanic", func(params panicParams) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, a
+}
+
+func startTcpServer(t *testing.T, server *RpcServer) uint16 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func expectCode(t *testing.T, err error, code int) {
+	rpcErr, ok := err.(*jsonrpc_cli.RpcError)
+	if !ok || rpcErr.Code != code {
+		t.Fatal("unexpected error", code, err)
+	}
+}
+
+func testClient(t *testing.T, client *jsonrpc_cli.RpcClient, a *account) {
+	var balance int64
+	err := client.RpcCall("account_balance", "alice", &balance)
+	if err != nil || balance != 5 {
+		t.Fatal("unexpected balance", balance, err)
+	}
+	var transfer string
+	err = client.RpcCall("account_transfer", transferParams{"a", "b", 3}, &transfer)
+	if err != nil || transfer != "a->b" {
+		t.Fatal("unexpected transfer", transfer, err)
+	}
+	var sum int
+	err = client.RpcCall("sum", []int{1, 2, 3}, &sum)
+	if err != nil || sum != 6 {
+		t.Fatal("unexpected sum", sum, err)
+	}
+
+	err = client.RpcCall("account_transfer", transferParams{"a", "b", 0}, &transfer)
+	expectCode(t, err, ErrCodeInvalidParams)
+	err = client.RpcCall("account_transfer", map[string]interface{}{"from": "a", "amount": 1, "memo": "x"}, &transfer)
+	expectCode(t, err, ErrCodeInvalidParams)
+	err = client.RpcCall("account_balance", []int{1, 2}, &balance)
+	expectCode(t, err, ErrCodeInvalidParams)
+	err = client.RpcCall("account_helper", nil, nil)
+	expectCode(t, err, ErrCodeMethodNotFound)
+	err = client.RpcCall("account_fail", nil, nil)
+	expectCode(t, err, 42)
+	err = client.RpcCall("plain", nil, nil)
+	expectCode(t, err, ErrCodeServer)
+	err = client.RpcCall("panic", nil, nil)
+	expectCode(t, err, ErrCodeInternal)
+	err = client.RpcCall("validatePanic", panicParams{"x"}, nil)
+	expectCode(t, err, ErrCodeInternal)
+
+	err = client.RpcNotify("account_notify", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-a.notified:
+		if message != "hello" {
+			t.Fatal("unexpected notification", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not handled")
+	}
+
+	calls := []*jsonrpc_cli.RpcBatchCall{
+		{Method: "sum", Arg: []int{1, 1}},
+		{Method: "account_notify", Arg: "batched", Notify: true},
+		{Method: "missing"},
+		{Method: "account_balance", Arg: "bob"},
+	}
+	err = client.RpcBatch(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls[0].Error != nil || calls[0].Result != float64(2) || calls[3].Error != nil || calls[3].Result != float64(3) {
+		t.Fatal("unexpected batch results", calls[0], calls[3])
+	}
+	expectCode(t, calls[2].Error, ErrCodeMethodNotFound)
+	select {
+	case <-a.notified:
+	case <-time.After(time.Second):
+		t.Fatal("batched notification not handled")
+	}
+}
+
+func TestRpcServerTcp(t *testing.T) {
+	server, a := newTestServer(t)
+	port := startTcpServer(t, server)
+	defer server.Close()
+
+	client := new(jsonrpc_cli.RpcClient)
+	err := client.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RpcDisConnect()
+	testClient(t, client, a)
+
+	server.Close()
+	err = client.RpcCall("sum", []int{1}, nil)
+	if err == nil {
+		t.Fatal("expected the connection to be closed by the server")
+	}
+}
+
+func TestRpcServerHttp(t *testing.T) {
+	server, a := newTestServer(t)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := new(jsonrpc_cli.RpcClient)
+	err := client.RpcConnectHttp(jsonrpc_cli.HttpConfig{URL: httpServer.URL, TimeOut: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RpcDisConnect()
+	testClient(t, client, a)
+}
+
+func TestRpcServerConcurrency(t *testing.T) {
+	server := new(RpcServer)
+	server.Initialise("TestServer")
+	server.SetMaxConcurrentRequests(2)
+	mutex := new(sync.Mutex)
+	active, maxActive := 0, 0
+	err := server.RegisterFunc("wait", func() error {
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := startTcpServer(t, server)
+	defer server.Close()
+
+	client := new(jsonrpc_cli.RpcClient)
+	err = client.RpcConnectV2("127.0.0.1", port, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RpcDisConnect()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.RpcCall("wait", nil, nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxActive != 2 {
+		t.Fatal("expected 2 handlers at the same time", maxActive)
+	}
+}
+
+func TestRpcServerInvalidMessages(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.SetMaxRequestSize(64)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	for _, c := range []struct {
+		body   string
+		status int
+		expect string
+	}{
+		{`{"jsonrpc":"2.0","method":"sum","params":[1],`, http.StatusOK, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`},
+		{`[]`, http.StatusOK, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}}`},
+		{`[1]`, http.StatusOK, `[{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}}]`},
+		{`{"jsonrpc":"1.0","method":"sum","id":1}`, http.StatusOK, `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"invalid request"}}`},
+		{`{"jsonrpc":"2.0","method":"plain"}`, http.StatusNoContent, ``},
+		{`{"jsonrpc":"2.0","method":"sum","params":[` + string(bytes.Repeat([]byte("1,"), 40)) + `1],"id":1}`, http.StatusRequestEntityTooLarge, ``},
+	} {
+		response, err := http.Post(httpServer.URL, "application/json", bytes.NewBufferString(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if response.StatusCode != c.status || string(body) != c.expect {
+			t.Fatal("unexpected response", c.body, response.StatusCode, string(body))
+		}
+	}
+
+	response, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("expected GET to be rejected", response.StatusCode)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	server := new(RpcServer)
+	server.Initialise("TestServer")
+	if server.RegisterFunc("bad", func(a, b int) error { return nil }) == nil {
+		t.Fatal("expected two params to be rejected")
+	}
+	if server.RegisterFunc("bad", func() int { return 0 }) == nil {
+		t.Fatal("expected a handler without error to be rejected")
+	}
+	if server.RegisterFunc("ok", func() error { return nil }) != nil {
+		t.Fatal("expected a valid handler to register")
+	}
+	if server.RegisterFunc("ok", func() error { return nil }) == nil {
+		t.Fatal("expected a duplicate method to be rejected")
+	}
+	if server.RegisterObject("x", struct{}{}) == nil {
+		t.Fatal("expected an object without handlers to be rejected")
+	}
+}
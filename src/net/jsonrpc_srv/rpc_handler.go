@@ -0,0 +1,157 @@
+package jsonrpc_srv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Validator is implemented by params which check themselves after decoding
+type Validator interface {
+	Validate() error
+}
+
+// rpcHandler calls a function of one of the forms
+//
+//	func([ctx context.Context,] [params P]) (R, error)
+//	func([ctx context.Context,] [params P]) error
+type rpcHandler struct {
+	fn         reflect.Value
+	hasContext bool
+	paramsType reflect.Type
+	hasResult  bool
+}
+
+func newRpcHandler(fn reflect.Value) (*rpcHandler, error) {
+	fnType := fn.Type()
+	if fnType.Kind() != reflect.Func {
+		return nil, errors.New("handler must be a function")
+	}
+	h := new(rpcHandler)
+	h.fn = fn
+	in := 0
+	if fnType.NumIn() > in && fnType.In(in) == contextType {
+		h.hasContext = true
+		in++
+	}
+	if fnType.NumIn() > in {
+		h.paramsType = fnType.In(in)
+		in++
+	}
+	if fnType.NumIn() != in || fnType.IsVariadic() {
+		return nil, errors.New("handler takes an optional context.Context and at most one params argument")
+	}
+	switch fnType.NumOut() {
+	case 1:
+		h.hasResult = false
+	case 2:
+		h.hasResult = true
+	default:
+		return nil, errors.New("handler must return (result, error) or error")
+	}
+	if fnType.Out(fnType.NumOut()-1) != errorType {
+		return nil, errors.New("the last result of a handler must be an error")
+	}
+	return h, nil
+}
+
+func decodeStrict(data []byte, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(target)
+	if err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after params")
+	}
+	return nil
+}
+
+// decodeParams decodes named params into a struct or map and positional params into a slice or array.
+// A single positional param is decoded into any other type, which is what clients send for scalars.
+func (h *rpcHandler) decodeParams(params json.RawMessage) (reflect.Value, error) {
+	value := reflect.New(h.paramsType)
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return value.Elem(), nil
+	}
+
+	target := h.paramsType
+	for target.Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+	positionalType := target.Kind() == reflect.Slice || target.Kind() == reflect.Array
+	if params[0] == '[' && !positionalType && target.Kind() != reflect.Interface {
+		var positional []json.RawMessage
+		err := json.Unmarshal(params, &positional)
+		if err != nil {
+			return value, err
+		}
+		if len(positional) != 1 {
+			return value, fmt.Errorf("expected 1 positional param, got %d", len(positional))
+		}
+		params = positional[0]
+	}
+	err := decodeStrict(params, value.Interface())
+	if err != nil {
+		return value, err
+	}
+
+	validator, ok := value.Elem().Interface().(Validator)
+	if !ok {
+		validator, ok = value.Interface().(Validator)
+	}
+	if ok && !(value.Elem().Kind() == reflect.Ptr && value.Elem().IsNil()) {
+		err = validator.Validate()
+		if err != nil {
+			return value, err
+		}
+	}
+	return value.Elem(), nil
+}
+
+func (h *rpcHandler) call(ctx context.Context, params json.RawMessage) (result interface{}, err error) {
+	// a panic of Validate or of the handler fails the call only
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			result = nil
+			err = &RpcError{Code: ErrCodeInternal, Message: fmt.Sprintf("handler panic: %v", recovered)}
+		}
+	}()
+	args := make([]reflect.Value, 0, 2)
+	if h.hasContext {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	if h.paramsType != nil {
+		value, decodeErr := h.decodeParams(params)
+		if decodeErr != nil {
+			return nil, &RpcError{Code: ErrCodeInvalidParams, Message: "invalid params: " + decodeErr.Error()}
+		}
+		args = append(args, value)
+	}
+	out := h.fn.Call(args)
+	errValue := out[len(out)-1]
+	if !errValue.IsNil() {
+		return nil, errValue.Interface().(error)
+	}
+	if h.hasResult {
+		return out[0].Interface(), nil
+	}
+	return nil, nil
+}
+
+// lowerFirst turns an exported Go method name into a method name, e.g. BlockNumber to blockNumber
+func lowerFirst(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
@@ -0,0 +1,12 @@
+package metrics
+
+import (
+	"strings"
+)
+
+// EscapeLabelValue escapes a label value of the Prometheus text exposition format
+func EscapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
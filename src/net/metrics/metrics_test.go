@@ -0,0 +1,10 @@
+package metrics
+
+import "testing"
+
+func TestEscapeLabelValue(t *testing.T) {
+	escaped := EscapeLabelValue("a\\b\"c\nd")
+	if escaped != `a\\b\"c\nd` {
+		t.Fatal("unexpected escaping", escaped)
+	}
+}
@@ -0,0 +1,201 @@
+package tcp_rpc
+
+import (
+	"errors"
+	"github.com/mutalisk999/go-lib/src/net/buffer_tcp"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var ErrClientClosed = errors.New("rpc client closed")
+var ErrCallTimeout = errors.New("rpc call timeout")
+
+type RpcClient struct {
+	mutex        *sync.Mutex
+	writeMutex   *sync.Mutex
+	conn         *buffer_tcp.BufferTcpConn
+	maxFrameSize uint32
+	nextId       uint64
+	pending      map[uint64]chan rpcFrame
+	closeErr     error
+	done         chan struct{}
+	heartbeat    *buffer_tcp.Heartbeat
+}
+
+func (c *RpcClient) RpcConnect(serverAddr string, serverPort uint16, timeOut float64) error {
+	conn := new(buffer_tcp.BufferTcpConn)
+	err := conn.TCPConnect(serverAddr, serverPort, timeOut)
+	if err != nil {
+		return err
+	}
+	c.Initialise(conn)
+	return nil
+}
+
+// Initialise starts multiplexing calls over an established connection
+func (c *RpcClient) Initialise(conn *buffer_tcp.BufferTcpConn) {
+	c.mutex = new(sync.Mutex)
+	c.writeMutex = new(sync.Mutex)
+	c.conn = conn
+	c.maxFrameSize = DefaultMaxFrameSize
+	c.nextId = 0
+	c.pending = make(map[uint64]chan rpcFrame)
+	c.closeErr = nil
+	c.done = make(chan struct{})
+	c.heartbeat = nil
+	go c.readLoop()
+}
+
+func (c *RpcClient) readLoop() {
+	var err error
+	for {
+		var payload []byte
+		payload, err = c.conn.TCPReadFrame(c.maxFrameSize)
+		if err != nil {
+			break
+		}
+		var frame rpcFrame
+		frame, err = decodeFrame(payload)
+		if err != nil {
+			break
+		}
+		if frame.frameType == FramePing {
+			_ = c.writeFrame(encodeControlFrame(FramePong))
+			continue
+		} else if frame.frameType == FramePong {
+			continue
+		}
+		c.mutex.Lock()
+		ch, ok := c.pending[frame.requestId]
+		if ok {
+			delete(c.pending, frame.requestId)
+		}
+		c.mutex.Unlock()
+		if ok {
+			ch <- frame
+		}
+	}
+	c.mutex.Lock()
+	heartbeat := c.heartbeat
+	c.mutex.Unlock()
+	if heartbeat != nil && heartbeat.Reason() != nil {
+		err = heartbeat.Reason()
+	}
+	c.shutdown(err)
+}
+
+// StartHeartbeat pings the server while the connection is quiet, after missThreshold unanswered
+// pings the connection is closed and pending calls fail with the heartbeat error. onDead may be nil.
+func (c *RpcClient) StartHeartbeat(interval time.Duration, missThreshold int, onDead func(error)) {
+	heartbeat := c.conn.StartHeartbeat(interval, missThreshold, func(*buffer_tcp.BufferTcpConn) error {
+		return c.writeFrame(encodeControlFrame(FramePing))
+	}, func(_ *buffer_tcp.BufferTcpConn, reason error) {
+		c.shutdown(reason)
+		if onDead != nil {
+			onDead(reason)
+		}
+	})
+	c.mutex.Lock()
+	c.heartbeat = heartbeat
+	c.mutex.Unlock()
+}
+
+func (c *RpcClient) shutdown(err error) {
+	c.mutex.Lock()
+	if c.closeErr == nil {
+		c.closeErr = err
+		if c.closeErr == nil {
+			c.closeErr = ErrClientClosed
+		}
+		close(c.done)
+	}
+	c.pending = make(map[uint64]chan rpcFrame)
+	c.mutex.Unlock()
+}
+
+func (c *RpcClient) writeFrame(payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	err := c.conn.TCPWriteFrame(payload)
+	if err != nil {
+		return err
+	}
+	return c.conn.TCPFlush()
+}
+
+// RpcCall sends req to methodId and waits for the reply unpacked as respType.
+// timeOut is in seconds, 0 waits until the connection is closed.
+func (c *RpcClient) RpcCall(methodId uint32, req interface{}, respType reflect.Type, timeOut float64) (interface{}, error) {
+	ch := make(chan rpcFrame, 1)
+	c.mutex.Lock()
+	if c.closeErr != nil {
+		err := c.closeErr
+		c.mutex.Unlock()
+		return nil, err
+	}
+	c.nextId++
+	requestId := c.nextId
+	c.pending[requestId] = ch
+	c.mutex.Unlock()
+
+	payload, err := encodeFrame(FrameRequest, requestId, methodId, req)
+	if err == nil {
+		err = c.writeFrame(payload)
+	}
+	if err != nil {
+		c.removePending(requestId)
+		return nil, err
+	}
+
+	var timeOutChan <-chan time.Time
+	if timeOut > 0 {
+		timer := time.NewTimer(time.Duration(timeOut * 1000 * 1000 * 1000))
+		defer timer.Stop()
+		timeOutChan = timer.C
+	}
+
+	select {
+	case frame := <-ch:
+		return replyOf(frame, respType)
+	case <-timeOutChan:
+		c.removePending(requestId)
+		return nil, ErrCallTimeout
+	case <-c.done:
+		select {
+		case frame := <-ch:
+			return replyOf(frame, respType)
+		default:
+		}
+		c.mutex.Lock()
+		err := c.closeErr
+		c.mutex.Unlock()
+		return nil, err
+	}
+}
+
+func replyOf(frame rpcFrame, respType reflect.Type) (interface{}, error) {
+	if frame.frameType == FrameError {
+		return nil, decodeError(frame.body)
+	}
+	return decodeBody(frame.body, respType)
+}
+
+func (c *RpcClient) removePending(requestId uint64) {
+	c.mutex.Lock()
+	delete(c.pending, requestId)
+	c.mutex.Unlock()
+}
+
+func (c *RpcClient) RpcDisConnect() {
+	c.mutex.Lock()
+	heartbeat := c.heartbeat
+	c.mutex.Unlock()
+	if heartbeat != nil {
+		heartbeat.Stop()
+	}
+	c.writeMutex.Lock()
+	_ = c.conn.TCPDisConnect()
+	c.writeMutex.Unlock()
+	c.shutdown(ErrClientClosed)
+}
@@ -0,0 +1,112 @@
+package tcp_rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/io/serialization"
+	"reflect"
+)
+
+const (
+	FrameRequest  = uint8(1)
+	FrameResponse = uint8(2)
+	FrameError    = uint8(3)
+	FramePing     = uint8(4)
+	FramePong     = uint8(5)
+)
+
+const (
+	ErrCodeUnknownMethod = uint32(1)
+	ErrCodeBadRequest    = uint32(2)
+	ErrCodeHandler       = uint32(3)
+)
+
+const DefaultMaxFrameSize = 4 * 1024 * 1024
+
+type RpcError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// rpcFrame is the payload of a buffer_tcp frame:
+// frameType(uint8) | requestId(uint64) | methodId(uint32) | body
+type rpcFrame struct {
+	frameType uint8
+	requestId uint64
+	methodId  uint32
+	body      []byte
+}
+
+func encodeFrame(frameType uint8, requestId uint64, methodId uint32, body interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_ = serialization.PackUint8(buf, frameType)
+	_ = serialization.PackUint64(buf, requestId)
+	_ = serialization.PackUint32(buf, methodId)
+	if body != nil {
+		err := serialization.Pack(buf, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeControlFrame(frameType uint8) []byte {
+	payload, _ := encodeFrame(frameType, 0, 0, nil)
+	return payload
+}
+
+func encodeErrorFrame(requestId uint64, methodId uint32, rpcErr *RpcError) []byte {
+	buf := new(bytes.Buffer)
+	_ = serialization.PackUint8(buf, FrameError)
+	_ = serialization.PackUint64(buf, requestId)
+	_ = serialization.PackUint32(buf, methodId)
+	_ = serialization.PackUint32(buf, rpcErr.Code)
+	_ = serialization.PackString(buf, rpcErr.Message)
+	return buf.Bytes()
+}
+
+func decodeFrame(payload []byte) (rpcFrame, error) {
+	reader := bytes.NewReader(payload)
+	frameType, err := serialization.UnPackUint8(reader)
+	if err != nil {
+		return rpcFrame{}, errors.New("decodeFrame: truncated frame")
+	}
+	requestId, err := serialization.UnPackUint64(reader)
+	if err != nil {
+		return rpcFrame{}, errors.New("decodeFrame: truncated frame")
+	}
+	methodId, err := serialization.UnPackUint32(reader)
+	if err != nil {
+		return rpcFrame{}, errors.New("decodeFrame: truncated frame")
+	}
+	return rpcFrame{frameType: frameType, requestId: requestId, methodId: methodId,
+		body: payload[len(payload)-reader.Len():]}, nil
+}
+
+// decodeBody unpacks a body from the network, the *bytes.Reader lets UnPack reject
+// length prefixes beyond the body before allocating
+func decodeBody(body []byte, ty reflect.Type) (interface{}, error) {
+	if ty == nil {
+		return nil, nil
+	}
+	return serialization.UnPack(bytes.NewReader(body), ty)
+}
+
+func decodeError(body []byte) *RpcError {
+	reader := bytes.NewReader(body)
+	code, err := serialization.UnPackUint32(reader)
+	if err != nil {
+		return &RpcError{Code: ErrCodeBadRequest, Message: "malformed error frame"}
+	}
+	message, err := serialization.UnPackString(reader)
+	if err != nil {
+		return &RpcError{Code: ErrCodeBadRequest, Message: "malformed error frame"}
+	}
+	return &RpcError{Code: code, Message: message}
+}
@@ -0,0 +1,277 @@
+package tcp_rpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mutalisk999/go-lib/src/net/buffer_tcp"
+	"github.com/mutalisk999/go-lib/src/sched/goroutine_mgr"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type RpcHandler func(req interface{}) (interface{}, error)
+
+type rpcMethod struct {
+	reqType reflect.Type
+	handler RpcHandler
+}
+
+// DefaultMaxConcurrentRequests bounds the requests handled at the same time
+const DefaultMaxConcurrentRequests = 1024
+
+type serverConn struct {
+	conn       *buffer_tcp.BufferTcpConn
+	writeMutex *sync.Mutex
+	slots      chan struct{}
+	// closed once the server stops serving the connection
+	done     chan struct{}
+	doneOnce *sync.Once
+}
+
+func (sc *serverConn) stop() {
+	sc.doneOnce.Do(func() {
+		close(sc.done)
+	})
+}
+
+func (sc *serverConn) writeFrame(payload []byte) error {
+	sc.writeMutex.Lock()
+	defer sc.writeMutex.Unlock()
+	err := sc.conn.TCPWriteFrame(payload)
+	if err != nil {
+		return err
+	}
+	return sc.conn.TCPFlush()
+}
+
+type RpcServer struct {
+	mutex        *sync.RWMutex
+	serverName   string
+	maxFrameSize uint32
+	requestSlots chan struct{}
+	methods      map[uint32]rpcMethod
+	goroutineMgr *goroutine_mgr.GoroutineManager
+	listener     *buffer_tcp.TcpListener
+	conns        map[*serverConn]bool
+	closed       bool
+
+	heartbeatInterval time.Duration
+	heartbeatMisses   int
+	heartbeatOnDead   func(*buffer_tcp.BufferTcpConn, error)
+}
+
+func (s *RpcServer) Initialise(serverName string) {
+	s.mutex = new(sync.RWMutex)
+	s.serverName = serverName
+	s.maxFrameSize = DefaultMaxFrameSize
+	s.requestSlots = make(chan struct{}, DefaultMaxConcurrentRequests)
+	s.methods = make(map[uint32]rpcMethod)
+	s.goroutineMgr = new(goroutine_mgr.GoroutineManager)
+	s.goroutineMgr.Initialise(serverName + ".GoroutineMgr")
+	s.listener = nil
+	s.conns = make(map[*serverConn]bool)
+	s.closed = false
+}
+
+// SetHeartbeat pings the clients which stay quiet for interval and drops them after missThreshold
+// unanswered pings. onDead may be nil.
+func (s *RpcServer) SetHeartbeat(interval time.Duration, missThreshold int, onDead func(*buffer_tcp.BufferTcpConn, error)) {
+	s.mutex.Lock()
+	s.heartbeatInterval = interval
+	s.heartbeatMisses = missThreshold
+	s.heartbeatOnDead = onDead
+	s.mutex.Unlock()
+}
+
+func (s *RpcServer) SetMaxFrameSize(maxFrameSize uint32) {
+	s.maxFrameSize = maxFrameSize
+}
+
+// SetMaxConcurrentRequests bounds the requests handled at the same time, 0 means unlimited.
+// A connection stops reading while no slot is free. It applies to the connections served afterwards.
+func (s *RpcServer) SetMaxConcurrentRequests(maxConcurrentRequests int) {
+	s.mutex.Lock()
+	s.requestSlots = nil
+	if maxConcurrentRequests > 0 {
+		s.requestSlots = make(chan struct{}, maxConcurrentRequests)
+	}
+	s.mutex.Unlock()
+}
+
+// RegisterHandler binds methodId to handler, requests are unpacked as reqType before the call
+func (s *RpcServer) RegisterHandler(methodId uint32, reqType reflect.Type, handler RpcHandler) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.methods[methodId]
+	if ok {
+		return errors.New(fmt.Sprintf("RegisterHandler: method %d already registered", methodId))
+	}
+	s.methods[methodId] = rpcMethod{reqType: reqType, handler: handler}
+	return nil
+}
+
+// Serve accepts connections until the listener or the server is closed
+func (s *RpcServer) Serve(listener *buffer_tcp.TcpListener) error {
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	for {
+		conn, err := listener.TCPAccept()
+		if err != nil {
+			s.mutex.RLock()
+			closed := s.closed
+			s.mutex.RUnlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.goroutineMgr.GoroutineCreateP1(s.serverName+".Conn", s.connCallBack, conn)
+	}
+}
+
+func (s *RpcServer) connCallBack(g goroutine_mgr.Goroutine, conn interface{}) {
+	defer g.OnQuit()
+	s.ServeConn(conn.(*buffer_tcp.BufferTcpConn))
+}
+
+// ServeConn serves requests of one connection until it is closed
+func (s *RpcServer) ServeConn(conn *buffer_tcp.BufferTcpConn) {
+	sc := &serverConn{conn: conn, writeMutex: new(sync.Mutex), done: make(chan struct{}), doneOnce: new(sync.Once)}
+	s.mutex.Lock()
+	sc.slots = s.requestSlots
+	if s.closed {
+		s.mutex.Unlock()
+		_ = conn.TCPDisConnect()
+		return
+	}
+	s.conns[sc] = true
+	heartbeatInterval := s.heartbeatInterval
+	heartbeatMisses := s.heartbeatMisses
+	heartbeatOnDead := s.heartbeatOnDead
+	s.mutex.Unlock()
+
+	if heartbeatInterval > 0 {
+		heartbeat := conn.StartHeartbeat(heartbeatInterval, heartbeatMisses, func(*buffer_tcp.BufferTcpConn) error {
+			return sc.writeFrame(encodeControlFrame(FramePing))
+		}, func(conn *buffer_tcp.BufferTcpConn, reason error) {
+			sc.stop()
+			if heartbeatOnDead != nil {
+				heartbeatOnDead(conn, reason)
+			}
+		})
+		defer heartbeat.Stop()
+	}
+
+	defer func() {
+		sc.stop()
+		s.mutex.Lock()
+		delete(s.conns, sc)
+		s.mutex.Unlock()
+		sc.writeMutex.Lock()
+		_ = conn.TCPDisConnect()
+		sc.writeMutex.Unlock()
+	}()
+
+	for {
+		payload, err := conn.TCPReadFrame(s.maxFrameSize)
+		if err != nil {
+			return
+		}
+		frame, err := decodeFrame(payload)
+		if err != nil {
+			return
+		}
+		if frame.frameType == FrameRequest {
+			if sc.slots != nil {
+				select {
+				case sc.slots <- struct{}{}:
+				case <-sc.done:
+					return
+				}
+			}
+			s.goroutineMgr.GoroutineCreateP2(s.serverName+".Request", s.requestCallBack, sc, frame)
+		} else if frame.frameType == FramePing {
+			_ = sc.writeFrame(encodeControlFrame(FramePong))
+		}
+	}
+}
+
+func (s *RpcServer) requestCallBack(g goroutine_mgr.Goroutine, sc interface{}, frame interface{}) {
+	defer g.OnQuit()
+	conn := sc.(*serverConn)
+	defer func() {
+		if conn.slots != nil {
+			<-conn.slots
+		}
+	}()
+	reqFrame := frame.(rpcFrame)
+	_ = conn.writeFrame(s.handleRequest(reqFrame))
+}
+
+func (s *RpcServer) handleRequest(frame rpcFrame) []byte {
+	s.mutex.RLock()
+	method, ok := s.methods[frame.methodId]
+	s.mutex.RUnlock()
+	if !ok {
+		return encodeErrorFrame(frame.requestId, frame.methodId,
+			&RpcError{Code: ErrCodeUnknownMethod, Message: fmt.Sprintf("unknown method %d", frame.methodId)})
+	}
+
+	req, err := decodeBody(frame.body, method.reqType)
+	if err != nil {
+		return encodeErrorFrame(frame.requestId, frame.methodId,
+			&RpcError{Code: ErrCodeBadRequest, Message: err.Error()})
+	}
+
+	resp, err := callHandler(method.handler, req)
+	if err != nil {
+		rpcErr, ok := err.(*RpcError)
+		if !ok {
+			rpcErr = &RpcError{Code: ErrCodeHandler, Message: err.Error()}
+		}
+		return encodeErrorFrame(frame.requestId, frame.methodId, rpcErr)
+	}
+
+	payload, err := encodeFrame(FrameResponse, frame.requestId, frame.methodId, resp)
+	if err != nil {
+		return encodeErrorFrame(frame.requestId, frame.methodId,
+			&RpcError{Code: ErrCodeHandler, Message: err.Error()})
+	}
+	return payload
+}
+
+// callHandler turns a panic of the handler into an error
+func callHandler(handler RpcHandler, req interface{}) (resp interface{}, err error) {
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			resp = nil
+			err = errors.New(fmt.Sprintf("handler panic: %v", recovered))
+		}
+	}()
+	return handler(req)
+}
+
+// Close stops Serve and disconnects the served connections
+func (s *RpcServer) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	listener := s.listener
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mutex.Unlock()
+
+	if listener != nil {
+		listener.TCPListenClose()
+	}
+	for _, sc := range conns {
+		sc.stop()
+		sc.writeMutex.Lock()
+		_ = sc.conn.TCPDisConnect()
+		sc.writeMutex.Unlock()
+	}
+}
@@ -0,0 +1,297 @@
+package tcp_rpc
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mutalisk999/go-lib/src/io/serialization"
+	"github.com/mutalisk999/go-lib/src/net/buffer_tcp"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type EchoRequest struct {
+	Text  string
+	Times int
+}
+
+func (g EchoRequest) Pack(writer io.Writer) error {
+	err := serialization.Pack(writer, g.Text)
+	if err != nil {
+		return err
+	}
+	return serialization.Pack(writer, g.Times)
+}
+
+func (g EchoRequest) UnPack(reader io.Reader) (EchoRequest, error) {
+	text, err := serialization.UnPack(reader, reflect.TypeOf(g.Text))
+	if err != nil {
+		return g, err
+	}
+	times, err := serialization.UnPack(reader, reflect.TypeOf(g.Times))
+	if err != nil {
+		return g, err
+	}
+	g.Text = text.(string)
+	g.Times = times.(int)
+	return g, nil
+}
+
+const (
+	methodEcho  = uint32(1)
+	methodFail  = uint32(2)
+	methodSleep = uint32(3)
+	methodPanic = uint32(4)
+)
+
+func startServer(t *testing.T) (*RpcServer, uint16) {
+	server := new(RpcServer)
+	server.Initialise("TestRpcServer")
+	_ = server.RegisterHandler(methodEcho, reflect.TypeOf(EchoRequest{}), func(req interface{}) (interface{}, error) {
+		echo := req.(EchoRequest)
+		return strings.Repeat(echo.Text, echo.Times), nil
+	})
+	_ = server.RegisterHandler(methodFail, nil, func(req interface{}) (interface{}, error) {
+		return nil, errors.New("always fails")
+	})
+	_ = server.RegisterHandler(methodSleep, reflect.TypeOf(int(0)), func(req interface{}) (interface{}, error) {
+		time.Sleep(time.Duration(req.(int)) * time.Millisecond)
+		return req, nil
+	})
+	_ = server.RegisterHandler(methodPanic, nil, func(req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if server.RegisterHandler(methodEcho, nil, nil) == nil {
+		t.Fatal("expected duplicate registration error")
+	}
+
+	listener := new(buffer_tcp.TcpListener)
+	err := listener.TCPListen(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server, uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestRpcCall(t *testing.T) {
+	server, port := startServer(t)
+	defer server.Close()
+
+	client := new(RpcClient)
+	err := client.RpcConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RpcDisConnect()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.RpcCall(methodEcho, EchoRequest{Text: strconv.Itoa(i), Times: 3}, reflect.TypeOf(""), 1)
+			if err != nil || resp.(string) != strings.Repeat(strconv.Itoa(i), 3) {
+				t.Error("unexpected echo", i, resp, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = client.RpcCall(methodFail, nil, nil, 1)
+	rpcErr, ok := err.(*RpcError)
+	if !ok || rpcErr.Code != ErrCodeHandler || rpcErr.Message != "always fails" {
+		t.Fatal("unexpected error", err)
+	}
+
+	_, err = client.RpcCall(methodPanic, nil, nil, 1)
+	rpcErr, ok = err.(*RpcError)
+	if !ok || rpcErr.Code != ErrCodeHandler || rpcErr.Message != "handler panic: boom" {
+		t.Fatal("unexpected error", err)
+	}
+
+	_, err = client.RpcCall(100, nil, nil, 1)
+	rpcErr, ok = err.(*RpcError)
+	if !ok || rpcErr.Code != ErrCodeUnknownMethod {
+		t.Fatal("unexpected error", err)
+	}
+
+	_, err = client.RpcCall(methodSleep, 200, reflect.TypeOf(int(0)), 0.05)
+	if err != ErrCallTimeout {
+		t.Fatal("expected timeout", err)
+	}
+	resp, err := client.RpcCall(methodSleep, 1, reflect.TypeOf(int(0)), 1)
+	if err != nil || resp.(int) != 1 {
+		t.Fatal("connection unusable after timeout", resp, err)
+	}
+}
+
+func TestRpcForgedLength(t *testing.T) {
+	server, port := startServer(t)
+	defer server.Close()
+
+	conn := new(buffer_tcp.BufferTcpConn)
+	err := conn.TCPConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.TCPDisConnect()
+	// the echo text claims 4 GB in a frame of a few bytes
+	payload := new(bytes.Buffer)
+	_ = serialization.PackUint8(payload, FrameRequest)
+	_ = serialization.PackUint64(payload, 1)
+	_ = serialization.PackUint32(payload, methodEcho)
+	_ = serialization.PackUint32(payload, 0xffffffff)
+	payload.WriteString("x")
+	_ = conn.TCPWriteFrame(payload.Bytes())
+	_ = conn.TCPFlush()
+
+	response, err := conn.TCPReadFrame(DefaultMaxFrameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := decodeFrame(response)
+	if err != nil || frame.frameType != FrameError {
+		t.Fatal("expected an error frame", frame, err)
+	}
+	if rpcErr := decodeError(frame.body); rpcErr.Code != ErrCodeBadRequest {
+		t.Fatal("unexpected error", rpcErr)
+	}
+}
+
+func TestRpcServerConcurrency(t *testing.T) {
+	server, port := startServer(t)
+	defer server.Close()
+	server.SetMaxConcurrentRequests(1)
+
+	client := new(RpcClient)
+	err := client.RpcConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RpcDisConnect()
+
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.RpcCall(methodSleep, 50, reflect.TypeOf(int(0)), 1)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatal("expected the requests to be handled one at a time", time.Since(start))
+	}
+}
+
+func TestRpcServerCloseWaitingSlot(t *testing.T) {
+	server, _ := startServer(t)
+	server.SetMaxConcurrentRequests(1)
+
+	serverSide, clientSide := net.Pipe()
+	conn := new(buffer_tcp.BufferTcpConn)
+	conn.TCPAttach(serverSide)
+	served := make(chan struct{})
+	go func() {
+		server.ServeConn(conn)
+		close(served)
+	}()
+
+	client := new(buffer_tcp.BufferTcpConn)
+	client.TCPAttach(clientSide)
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, client)
+	}()
+	for i := 0; i < 2; i++ {
+		payload, err := encodeFrame(FrameRequest, uint64(i+1), methodSleep, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.TCPWriteFrame(payload)
+		if err == nil {
+			err = client.TCPFlush()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second request waits for the slot of the first one
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+	select {
+	case <-served:
+	case <-time.After(150 * time.Millisecond):
+		t.Fatal("the connection kept waiting for a request slot after close")
+	}
+}
+
+func TestRpcServerClose(t *testing.T) {
+	server, port := startServer(t)
+
+	client := new(RpcClient)
+	err := client.RpcConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RpcDisConnect()
+
+	_, err = client.RpcCall(methodSleep, 1, reflect.TypeOf(int(0)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	_, err = client.RpcCall(methodSleep, 1, reflect.TypeOf(int(0)), 1)
+	if err == nil {
+		t.Fatal("expected an error after server close")
+	}
+}
+
+func TestRpcHeartbeat(t *testing.T) {
+	server, port := startServer(t)
+	defer server.Close()
+	dead := make(chan error, 1)
+	server.SetHeartbeat(20*time.Millisecond, 1, func(conn *buffer_tcp.BufferTcpConn, reason error) {
+		dead <- reason
+	})
+
+	client := new(RpcClient)
+	err := client.RpcConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.StartHeartbeat(10*time.Millisecond, 2, nil)
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.RpcCall(methodSleep, 1, reflect.TypeOf(int(0)), 1)
+	if err != nil {
+		t.Fatal("heartbeat broke a live connection", err)
+	}
+	client.RpcDisConnect()
+
+	silent := new(buffer_tcp.BufferTcpConn)
+	err = silent.TCPConnect("127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.TCPDisConnect()
+	select {
+	case reason := <-dead:
+		if !errors.Is(reason, buffer_tcp.ErrHeartbeatTimeout) {
+			t.Fatal("unexpected reason", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent client not dropped")
+	}
+}
@@ -0,0 +1,483 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// message types, which are the opcodes of the frames
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+const continuationFrame = 0
+
+// close codes of RFC 6455
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	CloseMessageTooLarge = 1009
+)
+
+const DefaultMaxMessageSize = 16 * 1024 * 1024
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+var ErrBadOrigin = errors.New("websocket: origin not allowed")
+var ErrMessageTooLarge = errors.New("websocket: message too large")
+var ErrInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text message")
+
+// CloseError is returned by ReadMessage once the peer sent a close frame
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: closed with code " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+// Conn is a websocket connection. ReadMessage must be called from one goroutine,
+// the write methods can be called concurrently.
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	client         bool
+	writeMutex     *sync.Mutex
+	maxMessageSize int64
+	closeSent      bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	c := new(Conn)
+	c.conn = conn
+	c.reader = reader
+	c.client = client
+	c.writeMutex = new(sync.Mutex)
+	c.maxMessageSize = DefaultMaxMessageSize
+	c.closeSent = false
+	return c
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Dial opens a ws:// or wss:// url, timeOut in seconds bounds connecting and the handshake
+func Dial(rawUrl string, header http.Header, timeOut float64) (*Conn, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: time.Duration(timeOut * 1000 * 1000 * 1000)}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", host)
+	case "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, errors.New("websocket: unsupported scheme " + u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := handshake(conn, u, header, timeOut)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func handshake(conn net.Conn, u *url.URL, header http.Header, timeOut float64) (*Conn, error) {
+	if timeOut > 0 {
+		_ = conn.SetDeadline(time.Now().Add(time.Duration(timeOut * 1000 * 1000 * 1000)))
+	}
+	nonce := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: make(http.Header)}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	err = request.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(response.Header, "Upgrade", "websocket") ||
+		response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, ErrBadHandshake
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return newConn(conn, reader, true), nil
+}
+
+type UpgradeOptions struct {
+	// tells the Origin of a browser request may connect, nil accepts requests without Origin
+	// and those whose Origin host is the request Host
+	CheckOrigin func(r *http.Request) bool
+}
+
+// sameOrigin accepts non-browser clients, which send no Origin, and pages of the same host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade takes over the connection of a websocket handshake request from the same origin,
+// see UpgradeWithOptions
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	return UpgradeWithOptions(w, r, UpgradeOptions{})
+}
+
+// UpgradeWithOptions takes over the connection of a websocket handshake request. Other requests are
+// answered with 400 Bad Request, requests of a rejected origin with 403 Forbidden, and an error is returned.
+func UpgradeWithOptions(w http.ResponseWriter, r *http.Request, options UpgradeOptions) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// SetMaxMessageSize limits the size of messages read, larger messages fail with ErrMessageTooLarge.
+// 0 or less removes the limit.
+func (c *Conn) SetMaxMessageSize(maxMessageSize int64) {
+	c.maxMessageSize = maxMessageSize
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	return c.writeFragment(opcode, true, payload)
+}
+
+func (c *Conn) writeFragment(opcode int, fin bool, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	if fin {
+		frame = append(frame, 0x80|byte(opcode))
+	} else {
+		frame = append(frame, byte(opcode))
+	}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	if c.client {
+		mask := make([]byte, 4)
+		_, err := io.ReadFull(rand.Reader, mask)
+		if err != nil {
+			return err
+		}
+		frame = append(frame, mask...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(mask, frame[start:])
+	} else {
+		frame = append(frame, payload...)
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func maskBytes(mask []byte, data []byte) {
+	for i := range data {
+		data[i] = data[i] ^ mask[i%4]
+	}
+}
+
+// WriteMessage sends data as a single frame of a text or binary message
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: invalid message type")
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// Ping sends a ping frame, the peer answers it with a pong which ReadMessage skips
+func (c *Conn) Ping(data []byte) error {
+	return c.writeFrame(PingMessage, data)
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	header := make([]byte, 2)
+	_, err = io.ReadFull(c.reader, header)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.protocolError("reserved bits set")
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, c.protocolError("invalid masking")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, extended)
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, extended)
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if opcode >= CloseMessage && (length > 125 || !fin) {
+		return false, 0, nil, c.protocolError("invalid control frame")
+	}
+	if c.maxMessageSize > 0 && length > uint64(c.maxMessageSize) {
+		_ = c.writeClose(CloseMessageTooLarge, "")
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	mask := make([]byte, 4)
+	if masked {
+		_, err = io.ReadFull(c.reader, mask)
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) protocolError(reason string) error {
+	_ = c.writeClose(CloseProtocolError, reason)
+	return errors.New("websocket: protocol error: " + reason)
+}
+
+func (c *Conn) writeClose(code int, text string) error {
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrame(CloseMessage, append(payload, text...))
+}
+
+// ReadMessage returns the next text or binary message, answering pings on the way.
+// A close frame of the peer is answered and returned as *CloseError, one with a reserved code
+// is a protocol error. Text messages which are not valid UTF-8 fail with ErrInvalidUTF8.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType := 0
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			err = c.writeFrame(PongMessage, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(payload) == 0 {
+				// 1005 must not be sent, a close without status is answered without one
+				_ = c.writeFrame(CloseMessage, nil)
+				return 0, nil, closeErr
+			}
+			if len(payload) == 1 {
+				return 0, nil, c.protocolError("truncated close code")
+			}
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Text = string(payload[2:])
+			if !validCloseCode(closeErr.Code) {
+				return 0, nil, c.protocolError("invalid close code " + strconv.Itoa(closeErr.Code))
+			}
+			if !utf8.Valid(payload[2:]) {
+				_ = c.writeClose(CloseInvalidPayload, "")
+				return 0, nil, ErrInvalidUTF8
+			}
+			_ = c.writeClose(closeErr.Code, "")
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.protocolError("unfinished message")
+			}
+			messageType = opcode
+			message = payload
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.protocolError("unexpected continuation")
+			}
+			if c.maxMessageSize > 0 && int64(len(message)+len(payload)) > c.maxMessageSize {
+				_ = c.writeClose(CloseMessageTooLarge, "")
+				return 0, nil, ErrMessageTooLarge
+			}
+			message = append(message, payload...)
+		default:
+			return 0, nil, c.protocolError("unknown opcode " + strconv.Itoa(opcode))
+		}
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				_ = c.writeClose(CloseInvalidPayload, "")
+				return 0, nil, ErrInvalidUTF8
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// validCloseCode reports the codes a close frame may carry, 1005, 1006 and 1015 are
+// reserved for reporting locally and never sent
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// Close sends a normal close frame and closes the connection without waiting for the peer
+func (c *Conn) Close() error {
+	// a write blocked on a dead peer must not hold up the close
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.writeClose(CloseNormal, "")
+	return c.conn.Close()
+}
@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startEchoServer echoes every message, "close" makes it close the connection
+func startEchoServer(t *testing.T) (string, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetMaxMessageSize(1024)
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(message) == "close" {
+				_ = conn.writeClose(CloseGoingAway, "bye")
+				return
+			}
+			err = conn.WriteMessage(messageType, message)
+			if err != nil {
+				return
+			}
+		}
+	}))
+	return "ws" + strings.TrimPrefix(server.URL, "http"), server.Close
+}
+
+func TestEcho(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn, err := Dial(url, http.Header{"X-Trace": {"abc"}}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, message := range [][]byte{[]byte("hello"), bytes.Repeat([]byte("x"), 200), bytes.Repeat([]byte("y"), 1000)} {
+		err = conn.WriteMessage(BinaryMessage, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messageType, echo, err := conn.ReadMessage()
+		if err != nil || messageType != BinaryMessage || !bytes.Equal(echo, message) {
+			t.Fatal("unexpected echo", messageType, len(echo), err)
+		}
+	}
+
+	// a fragmented message with a ping in between
+	_ = conn.writeFragment(TextMessage, false, []byte("frag"))
+	_ = conn.Ping([]byte("p"))
+	_ = conn.writeFragment(continuationFrame, true, []byte("mented"))
+	messageType, echo, err := conn.ReadMessage()
+	if err != nil || messageType != TextMessage || string(echo) != "fragmented" {
+		t.Fatal("unexpected echo of a fragmented message", messageType, string(echo), err)
+	}
+
+	_ = conn.WriteMessage(TextMessage, []byte("close"))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Text != "bye" {
+		t.Fatal("expected the close of the server", err)
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn, err := Dial(url, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 0 reads messages of any size
+	conn.SetMaxMessageSize(0)
+	_ = conn.WriteMessage(BinaryMessage, bytes.Repeat([]byte("z"), 1000))
+	_, echo, err := conn.ReadMessage()
+	if err != nil || len(echo) != 1000 {
+		t.Fatal("unexpected echo without a limit", len(echo), err)
+	}
+
+	_ = conn.WriteMessage(TextMessage, bytes.Repeat([]byte("z"), 2000))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooLarge {
+		t.Fatal("expected the server to refuse the message", err)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	response, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected a plain request to be refused", response.StatusCode)
+	}
+
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	_, err = Dial("ws"+strings.TrimPrefix(plain.URL, "http"), nil, 3)
+	if err != ErrBadHandshake {
+		t.Fatal("expected a bad handshake", err)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	host := strings.TrimPrefix(url, "ws://")
+	conn, err := Dial(url, http.Header{"Origin": {"http://" + host}}, 3)
+	if err != nil {
+		t.Fatal("expected the same origin to connect", err)
+	}
+	_ = conn.Close()
+	_, err = Dial(url, http.Header{"Origin": {"http://evil.example"}}, 3)
+	if err != ErrBadHandshake {
+		t.Fatal("expected a foreign origin to be refused", err)
+	}
+
+	open := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := UpgradeWithOptions(w, r, UpgradeOptions{CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "http://trusted.example"
+		}})
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer open.Close()
+	conn, err = Dial("ws"+strings.TrimPrefix(open.URL, "http"), http.Header{"Origin": {"http://trusted.example"}}, 3)
+	if err != nil {
+		t.Fatal("expected CheckOrigin to accept the origin", err)
+	}
+	_ = conn.Close()
+}
+
+func TestCloseWithoutStatus(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn, err := Dial(url, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.writeFrame(CloseMessage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, opcode, payload, err := conn.readFrame()
+	if err != nil || opcode != CloseMessage || len(payload) != 0 {
+		t.Fatal("expected a close frame without status", opcode, payload, err)
+	}
+}
+
+func TestCloseCodes(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	for _, c := range []struct {
+		code   int
+		expect int
+	}{
+		{CloseGoingAway, CloseGoingAway},
+		{4000, 4000},
+		{999, CloseProtocolError},
+		{CloseNoStatus, CloseProtocolError},
+		{1006, CloseProtocolError},
+		{1015, CloseProtocolError},
+	} {
+		conn, err := Dial(url, nil, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.writeClose(c.code, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, opcode, payload, err := conn.readFrame()
+		if err != nil || opcode != CloseMessage || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != c.expect {
+			t.Fatal("unexpected close answer", c.code, opcode, payload, err)
+		}
+		_ = conn.conn.Close()
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn, err := Dial(url, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.WriteMessage(TextMessage, []byte{'a', 0xff, 'b'})
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseInvalidPayload {
+		t.Fatal("expected the server to refuse the text", err)
+	}
+}
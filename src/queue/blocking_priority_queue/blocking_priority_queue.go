@@ -6,17 +6,21 @@ import (
 	"sync"
 )
 
-type BlockingPriorityQueue struct {
+// TypedBlockingPriorityQueue is a heap of T ordered by cmpFunc, whose blocking calls wait for an element or for room
+type TypedBlockingPriorityQueue[T any] struct {
 	mutex     *sync.RWMutex
 	emptyCond *sync.Cond
 	fullCond  *sync.Cond
 	fullSize  uint64
 	queueType int
-	cmpFunc   func(l interface{}, r interface{}) int
-	queue     []interface{}
+	cmpFunc   func(l T, r T) int
+	queue     []T
 }
 
-func swapQueueElem(queue []interface{}, leftIndex int, rightIndex int) error {
+// BlockingPriorityQueue is the queue of any values, kept for the callers written before TypedBlockingPriorityQueue
+type BlockingPriorityQueue = TypedBlockingPriorityQueue[interface{}]
+
+func swapQueueElem[T any](queue []T, leftIndex int, rightIndex int) error {
 	if leftIndex < 0 || leftIndex >= len(queue) {
 		return errors.New(fmt.Sprintf("invalid leftIndex: %d", leftIndex))
 	}
@@ -27,7 +31,7 @@ func swapQueueElem(queue []interface{}, leftIndex int, rightIndex int) error {
 	return nil
 }
 
-func (b *BlockingPriorityQueue) Initialise(queueType int, cmpFunc func(l interface{}, r interface{}) int, maxSize uint64) error {
+func (b *TypedBlockingPriorityQueue[T]) Initialise(queueType int, cmpFunc func(l T, r T) int, maxSize uint64) error {
 	if queueType != 1 && queueType != 2 {
 		return errors.New(fmt.Sprintf("invalid queueType: %d", queueType))
 	}
@@ -43,23 +47,24 @@ func (b *BlockingPriorityQueue) Initialise(queueType int, cmpFunc func(l interfa
 	// 2 -- big root heap
 	b.queueType = queueType
 	b.cmpFunc = cmpFunc
-	b.queue = make([]interface{}, 0)
+	b.queue = make([]T, 0)
 	return nil
 }
 
-func (b BlockingPriorityQueue) QueueSize() int {
+func (b TypedBlockingPriorityQueue[T]) QueueSize() int {
 	b.mutex.RLock()
 	queueSize := len(b.queue)
 	b.mutex.RUnlock()
 	return queueSize
 }
 
-func (b BlockingPriorityQueue) Top() (interface{}, error) {
+func (b TypedBlockingPriorityQueue[T]) Top() (T, error) {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if queueSize <= 0 {
 		b.mutex.Unlock()
-		return nil, errors.New("Top: empty queue")
+		var zero T
+		return zero, errors.New("Top: empty queue")
 	} else {
 		queueElem := b.queue[0]
 		b.mutex.Unlock()
@@ -69,7 +74,7 @@ func (b BlockingPriorityQueue) Top() (interface{}, error) {
 	//return nil, nil
 }
 
-func (b *BlockingPriorityQueue) siftDown() {
+func (b *TypedBlockingPriorityQueue[T]) siftDown() {
 	if len(b.queue) > 0 {
 		parentIndex := 0
 		for {
@@ -130,12 +135,13 @@ func (b *BlockingPriorityQueue) siftDown() {
 	}
 }
 
-func (b *BlockingPriorityQueue) Pop() (interface{}, error) {
+func (b *TypedBlockingPriorityQueue[T]) Pop() (T, error) {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if queueSize <= 0 {
 		b.mutex.Unlock()
-		return nil, errors.New("Pop: empty queue")
+		var zero T
+		return zero, errors.New("Pop: empty queue")
 	} else {
 		_ = swapQueueElem(b.queue, 0, len(b.queue)-1)
 		queueElem := b.queue[len(b.queue)-1]
@@ -149,7 +155,7 @@ func (b *BlockingPriorityQueue) Pop() (interface{}, error) {
 	//return nil, nil
 }
 
-func (b *BlockingPriorityQueue) PopBlocking() interface{} {
+func (b *TypedBlockingPriorityQueue[T]) PopBlocking() T {
 	b.mutex.Lock()
 	for {
 		queueSize := len(b.queue)
@@ -174,7 +180,7 @@ func (b *BlockingPriorityQueue) PopBlocking() interface{} {
 	return queueElem
 }
 
-func (b *BlockingPriorityQueue) siftUp() {
+func (b *TypedBlockingPriorityQueue[T]) siftUp() {
 	if len(b.queue) > 0 {
 		childIndex := len(b.queue) - 1
 		for {
@@ -202,7 +208,7 @@ func (b *BlockingPriorityQueue) siftUp() {
 	}
 }
 
-func (b *BlockingPriorityQueue) Push(elem interface{}) error {
+func (b *TypedBlockingPriorityQueue[T]) Push(elem T) error {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if b.fullSize != 0 && uint64(queueSize) >= b.fullSize {
@@ -219,7 +225,7 @@ func (b *BlockingPriorityQueue) Push(elem interface{}) error {
 	//return nil
 }
 
-func (b *BlockingPriorityQueue) PushBlocking(elem interface{}) {
+func (b *TypedBlockingPriorityQueue[T]) PushBlocking(elem T) {
 	b.mutex.Lock()
 	if b.fullCond != nil {
 		for {
@@ -238,12 +244,12 @@ func (b *BlockingPriorityQueue) PushBlocking(elem interface{}) {
 	b.mutex.Unlock()
 }
 
-func (b *BlockingPriorityQueue) Destroy() {
+func (b *TypedBlockingPriorityQueue[T]) Destroy() {
 	b.mutex = nil
 	b.emptyCond = nil
 	b.fullCond = nil
 	b.fullSize = 0
 	b.queueType = 0
 	b.cmpFunc = nil
-	b.queue = make([]interface{}, 0)
+	b.queue = make([]T, 0)
 }
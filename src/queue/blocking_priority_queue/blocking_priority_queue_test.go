@@ -56,3 +56,21 @@ func TestBigger(t *testing.T) {
 		}
 	}
 }
+
+func TestTyped(t *testing.T) {
+	queue := new(TypedBlockingPriorityQueue[int])
+	_ = queue.Initialise(1, func(l int, r int) int { return l - r }, 0)
+
+	_, err := queue.Pop()
+	if err == nil {
+		t.Fatal("expected an empty queue")
+	}
+	for _, v := range []int{8, 5, 10, 2, 1} {
+		queue.PushBlocking(v)
+	}
+	for _, want := range []int{1, 2, 5, 8, 10} {
+		if v := queue.PopBlocking(); v != want {
+			t.Fatal("unexpected elem", v, want)
+		}
+	}
+}
@@ -5,15 +5,19 @@ import (
 	"sync"
 )
 
-type BlockingQueue struct {
+// TypedBlockingQueue is a FIFO queue of T, whose blocking calls wait for an element or for room
+type TypedBlockingQueue[T any] struct {
 	mutex     *sync.RWMutex
 	emptyCond *sync.Cond
 	fullCond  *sync.Cond
 	fullSize  uint64
-	queue     []interface{}
+	queue     []T
 }
 
-func (b *BlockingQueue) Initialise(maxSize uint64) {
+// BlockingQueue is the queue of any values, kept for the callers written before TypedBlockingQueue
+type BlockingQueue = TypedBlockingQueue[interface{}]
+
+func (b *TypedBlockingQueue[T]) Initialise(maxSize uint64) {
 	b.mutex = new(sync.RWMutex)
 	b.emptyCond = sync.NewCond(b.mutex)
 	if maxSize != 0 {
@@ -22,22 +26,23 @@ func (b *BlockingQueue) Initialise(maxSize uint64) {
 		b.fullCond = nil
 	}
 	b.fullSize = maxSize
-	b.queue = make([]interface{}, 0)
+	b.queue = make([]T, 0)
 }
 
-func (b BlockingQueue) QueueSize() int {
+func (b TypedBlockingQueue[T]) QueueSize() int {
 	b.mutex.RLock()
 	queueSize := len(b.queue)
 	b.mutex.RUnlock()
 	return queueSize
 }
 
-func (b BlockingQueue) Top() (interface{}, error) {
+func (b TypedBlockingQueue[T]) Top() (T, error) {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if queueSize <= 0 {
 		b.mutex.Unlock()
-		return nil, errors.New("Top: empty queue")
+		var zero T
+		return zero, errors.New("Top: empty queue")
 	} else {
 		queueElem := b.queue[0]
 		b.mutex.Unlock()
@@ -47,12 +52,13 @@ func (b BlockingQueue) Top() (interface{}, error) {
 	//return nil, nil
 }
 
-func (b BlockingQueue) Back() (interface{}, error) {
+func (b TypedBlockingQueue[T]) Back() (T, error) {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if queueSize <= 0 {
 		b.mutex.Unlock()
-		return nil, errors.New("Back: empty queue")
+		var zero T
+		return zero, errors.New("Back: empty queue")
 	} else {
 		queueElem := b.queue[queueSize-1]
 		b.mutex.Unlock()
@@ -62,12 +68,13 @@ func (b BlockingQueue) Back() (interface{}, error) {
 	//return nil, nil
 }
 
-func (b *BlockingQueue) PopFront() (interface{}, error) {
+func (b *TypedBlockingQueue[T]) PopFront() (T, error) {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if queueSize <= 0 {
 		b.mutex.Unlock()
-		return nil, errors.New("PopFront: empty queue")
+		var zero T
+		return zero, errors.New("PopFront: empty queue")
 	} else {
 		queueElem := b.queue[0]
 		b.queue = b.queue[1:]
@@ -78,7 +85,7 @@ func (b *BlockingQueue) PopFront() (interface{}, error) {
 	//return nil, nil
 }
 
-func (b *BlockingQueue) PopFrontBlocking() interface{} {
+func (b *TypedBlockingQueue[T]) PopFrontBlocking() T {
 	b.mutex.Lock()
 	for {
 		queueSize := len(b.queue)
@@ -99,7 +106,7 @@ func (b *BlockingQueue) PopFrontBlocking() interface{} {
 	return queueElem
 }
 
-func (b *BlockingQueue) PushBack(elem interface{}) error {
+func (b *TypedBlockingQueue[T]) PushBack(elem T) error {
 	b.mutex.Lock()
 	queueSize := len(b.queue)
 	if b.fullSize != 0 && uint64(queueSize) >= b.fullSize {
@@ -114,7 +121,7 @@ func (b *BlockingQueue) PushBack(elem interface{}) error {
 	//return nil
 }
 
-func (b *BlockingQueue) PushBackBlocking(elem interface{}) {
+func (b *TypedBlockingQueue[T]) PushBackBlocking(elem T) {
 	b.mutex.Lock()
 	if b.fullCond != nil {
 		for {
@@ -131,10 +138,10 @@ func (b *BlockingQueue) PushBackBlocking(elem interface{}) {
 	b.mutex.Unlock()
 }
 
-func (b *BlockingQueue) Destroy() {
+func (b *TypedBlockingQueue[T]) Destroy() {
 	b.mutex = nil
 	b.emptyCond = nil
 	b.fullCond = nil
 	b.fullSize = 0
-	b.queue = make([]interface{}, 0)
+	b.queue = make([]T, 0)
 }
@@ -47,3 +47,29 @@ func TestAll(t *testing.T) {
 		time.Sleep(1 * time.Second)
 	}
 }
+
+func TestTyped(t *testing.T) {
+	queue := new(TypedBlockingQueue[string])
+	queue.Initialise(2)
+
+	_, err := queue.PopFront()
+	if err == nil {
+		t.Fatal("expected an empty queue")
+	}
+	_ = queue.PushBack("a")
+	_ = queue.PushBack("b")
+	if queue.PushBack("c") == nil {
+		t.Fatal("expected a full queue")
+	}
+	done := make(chan struct{})
+	go func() {
+		queue.PushBackBlocking("c")
+		close(done)
+	}()
+	for _, want := range []string{"a", "b", "c"} {
+		if elem := queue.PopFrontBlocking(); elem != want {
+			t.Fatal("unexpected elem", elem, want)
+		}
+	}
+	<-done
+}
@@ -17,7 +17,7 @@ type PriorityTaskMgr struct {
 	goroutineCount    uint64
 	goroutineMgr      *goroutine_mgr.GoroutineManager
 	goroutineQuit     []chan error
-	taskQueue         *blocking_priority_queue.BlockingPriorityQueue
+	taskQueue         *blocking_priority_queue.TypedBlockingPriorityQueue[PriorityTask]
 	taskQueueBlocking bool
 }
 
@@ -28,8 +28,11 @@ func (t *PriorityTaskMgr) Initialise(taskMgrName string, goroutineCount uint64,
 	t.goroutineMgr = new(goroutine_mgr.GoroutineManager)
 	t.goroutineMgr.Initialise(t.taskMgrName + ".GoroutineMgr")
 	t.goroutineQuit = make([]chan error, 0)
-	t.taskQueue = new(blocking_priority_queue.BlockingPriorityQueue)
-	_ = t.taskQueue.Initialise(2, taskCmpFunc, taskQueueMaxSize)
+	t.taskQueue = new(blocking_priority_queue.TypedBlockingPriorityQueue[PriorityTask])
+	// taskCmpFunc compares the tasks as interface{} values
+	_ = t.taskQueue.Initialise(2, func(l PriorityTask, r PriorityTask) int {
+		return taskCmpFunc(l, r)
+	}, taskQueueMaxSize)
 	t.taskQueueBlocking = taskQueueBlocking
 }
 
@@ -48,14 +51,9 @@ func (t *PriorityTaskMgr) PushTask(task PriorityTask) error {
 
 func (t *PriorityTaskMgr) PopTask() (PriorityTask, error) {
 	if t.taskQueueBlocking {
-		elem := t.taskQueue.PopBlocking()
-		return elem.(PriorityTask), nil
+		return t.taskQueue.PopBlocking(), nil
 	} else {
-		elem, err := t.taskQueue.Pop()
-		if err != nil {
-			return PriorityTask{}, err
-		}
-		return elem.(PriorityTask), nil
+		return t.taskQueue.Pop()
 	}
 }
 
@@ -16,7 +16,7 @@ type TaskMgr struct {
 	goroutineCount    uint64
 	goroutineMgr      *goroutine_mgr.GoroutineManager
 	goroutineQuit     []chan error
-	taskQueue         *blocking_queue.BlockingQueue
+	taskQueue         *blocking_queue.TypedBlockingQueue[Task]
 	taskQueueBlocking bool
 }
 
@@ -26,7 +26,7 @@ func (t *TaskMgr) Initialise(taskMgrName string, goroutineCount uint64, taskQueu
 	t.goroutineMgr = new(goroutine_mgr.GoroutineManager)
 	t.goroutineMgr.Initialise(t.taskMgrName + ".GoroutineMgr")
 	t.goroutineQuit = make([]chan error, 0)
-	t.taskQueue = new(blocking_queue.BlockingQueue)
+	t.taskQueue = new(blocking_queue.TypedBlockingQueue[Task])
 	t.taskQueue.Initialise(taskQueueMaxSize)
 	t.taskQueueBlocking = taskQueueBlocking
 }
@@ -46,14 +46,9 @@ func (t *TaskMgr) PushTask(task Task) error {
 
 func (t *TaskMgr) PopTask() (Task, error) {
 	if t.taskQueueBlocking {
-		elem := t.taskQueue.PopFrontBlocking()
-		return elem.(Task), nil
+		return t.taskQueue.PopFrontBlocking(), nil
 	} else {
-		elem, err := t.taskQueue.PopFront()
-		if err != nil {
-			return Task{}, err
-		}
-		return elem.(Task), nil
+		return t.taskQueue.PopFront()
 	}
 }
 